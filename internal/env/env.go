@@ -5,6 +5,7 @@ package env
 import (
 	"os"
 	"strconv"
+	"time"
 )
 
 // Int returns the environment variable for key, and defaults to value if it's not found.
@@ -31,6 +32,19 @@ func Float64(key string, value float64) float64 {
 	return f
 }
 
+// Duration returns the environment variable for key, and defaults to value if it's not found.
+// The env var is parsed by time.ParseDuration, e.g. "30s" or "1m30s".
+//
+// IMPORTANT NOTE - if the env var is not a duration, the value 0 will be returned
+func Duration(key string, value time.Duration) time.Duration {
+	str, exists := os.LookupEnv(key)
+	if !exists {
+		return value
+	}
+	d, _ := time.ParseDuration(str)
+	return d
+}
+
 // String returns the environment variable for key, and defaults to value if it's not found.
 func String(key string, value string) string {
 	str, exists := os.LookupEnv(key)
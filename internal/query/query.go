@@ -2,6 +2,7 @@ package query
 
 import (
 	"fmt"
+	"math"
 	"net/url"
 	"strconv"
 )
@@ -50,7 +51,8 @@ func Float64(uv url.Values, key string, value float64, min, max *float64) (float
 
 	queryValue, err := strconv.ParseFloat(values[0], 64)
 
-	if err != nil {
+	// NaN and infinity parse, but slip past min and max, and are never meant
+	if err != nil || math.IsNaN(queryValue) || math.IsInf(queryValue, 0) {
 		return 0, ok, fmt.Errorf("%s is not a float", values[0])
 	}
 
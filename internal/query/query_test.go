@@ -81,6 +81,7 @@ func TestFloat64(t *testing.T) {
 	}{
 		{in: in{url.Values(map[string][]string{"zoom": []string{"1"}}), "zoom", 0, nil, nil}, expect: expect{1, true, nil}},
 		{in: in{url.Values(map[string][]string{"zoom": []string{"abc"}}), "zoom", 0, nil, nil}, expect: expect{0, true, errors.New("abc is not a float")}},
+		{in: in{url.Values(map[string][]string{"zoom": []string{"NaN"}}), "zoom", 0, &min, &max}, expect: expect{0, true, errors.New("NaN is not a float")}},
 		{in: in{url.Values(map[string][]string{"zoom": []string{"2.2"}}), "zoom", 0, &min, &max}, expect: expect{2.2, true, nil}},
 		{in: in{url.Values(map[string][]string{"zoom": []string{"0"}}), "zoom", 0, &min, &max}, expect: expect{0, true, nil}},
 		{in: in{url.Values(map[string][]string{"zoom": []string{"-1"}}), "zoom", 0, &min, &max}, expect: expect{-1, true, errors.New("-1 is lower than 0.000000")}},
@@ -0,0 +1,350 @@
+package sqlite
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Object is an entry in the schema table, i.e. a table, index, view or trigger
+type Object struct {
+	Type     string
+	Name     string
+	Table    string
+	RootPage int
+	SQL      string
+
+	// keys are the columns of an index, see Schema
+	keys []key
+}
+
+// key is a column of an index
+type key struct {
+	name      string
+	desc      bool
+	collation string // in upper case, and empty for the default BINARY
+}
+
+// Schema returns the objects in the database
+func (db *DB) Schema() ([]Object, error) {
+	var objects []Object
+	var err error
+
+	// the schema table has root page 1, and the columns type, name, tbl_name, rootpage and sql
+	scanErr := db.Scan(1, func(rowid int64, values []interface{}) bool {
+		if len(values) < 5 {
+			err = fmt.Errorf("schema row %d has %d columns, want 5", rowid, len(values))
+			return false
+		}
+
+		o := Object{}
+		o.Type, _ = values[0].(string)
+		o.Name, _ = values[1].(string)
+		o.Table, _ = values[2].(string)
+		rootPage, _ := values[3].(int64)
+		o.RootPage = int(rootPage)
+		o.SQL, _ = values[4].(string)
+
+		objects = append(objects, o)
+		return true
+	})
+	if scanErr != nil {
+		return nil, scanErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tables := map[string]Object{}
+	for _, o := range objects {
+		if o.Type == "table" {
+			tables[o.Name] = o
+		}
+	}
+
+	// Indexes get their columns from their CREATE INDEX statement. The automatic indexes of PRIMARY KEY and UNIQUE constraints have none,
+	// and get them from the constraints of their table instead. They are named sqlite_autoindex_<table>_<n>, with n counting the constraints in order.
+	for i, o := range objects {
+		if o.Type != "index" {
+			continue
+		}
+
+		table := tables[o.Table]
+		if o.SQL != "" {
+			objects[i].keys = parseKeys(o.SQL)
+		} else if strings.HasPrefix(o.Name, "sqlite_autoindex_") {
+			n, _ := strconv.Atoi(o.Name[strings.LastIndex(o.Name, "_")+1:])
+			constraints := table.constraints()
+			if n < 1 || n > len(constraints) {
+				return nil, fmt.Errorf("could not find the constraint of index %s in table %s", o.Name, o.Table)
+			}
+			objects[i].keys = constraints[n-1]
+		}
+
+		// columns have the collation of the table, unless the index has its own
+		collations := table.collations()
+		for j, k := range objects[i].keys {
+			if k.collation == "" {
+				objects[i].keys[j].collation = collations[k.name]
+			}
+		}
+	}
+
+	return objects, nil
+}
+
+// Columns returns the column names of a table or index, parsed from its CREATE statement.
+// Table constraints, like PRIMARY KEY (a, b), are skipped. The columns of automatic indexes come from the constraints of their table.
+func (o Object) Columns() []string {
+	if o.Type == "index" {
+		var columns []string
+		for _, k := range o.keys {
+			columns = append(columns, k.name)
+		}
+		return columns
+	}
+
+	var columns []string
+	for _, def := range columnDefs(o.SQL) {
+		columns = append(columns, firstWord(def))
+	}
+
+	return columns
+}
+
+// columnDefs returns the column definitions of a CREATE TABLE statement, without the table constraints
+func columnDefs(sql string) []string {
+	var defs []string
+	for _, def := range definitions(sql) {
+		if !isConstraint(def) {
+			defs = append(defs, def)
+		}
+	}
+	return defs
+}
+
+// definitions returns the comma separated definitions between the outer parentheses of a CREATE statement
+func definitions(sql string) []string {
+	start, end := outerParentheses(sql)
+	if start < 0 {
+		return nil
+	}
+	return splitTopLevel(sql[start+1 : end])
+}
+
+// outerParentheses returns the index of the first opening parenthesis in sql, and of the closing parenthesis that matches it.
+// They are -1 if there is none. What comes after, like the WHERE clause of a partial index, can have parentheses too.
+func outerParentheses(sql string) (int, int) {
+	start := strings.Index(sql, "(")
+	if start < 0 {
+		return -1, -1
+	}
+
+	depth := 0
+	var quote rune
+	for i, r := range sql[start:] {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '[':
+			quote = ']'
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+			if depth == 0 {
+				return start, start + i
+			}
+		}
+	}
+
+	return -1, -1
+}
+
+// Partial reports if an index is a partial index, with a WHERE clause, which only has entries for some of the rows of its table.
+// Partial indexes can not be used to look up rows, as a row that is not found can still be in the table.
+func (o Object) Partial() bool {
+	if o.Type != "index" {
+		return false
+	}
+
+	_, end := outerParentheses(o.SQL)
+	if end < 0 {
+		return false
+	}
+
+	for _, word := range strings.Fields(strings.ToUpper(o.SQL[end+1:])) {
+		if word == "WHERE" {
+			return true
+		}
+	}
+	return false
+}
+
+// isConstraint reports if def, from the definitions of a CREATE TABLE statement, is a table constraint and not a column
+func isConstraint(def string) bool {
+	switch strings.ToUpper(firstWord(def)) {
+	case "", "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+		return true
+	}
+	return false
+}
+
+// parseKeys parses the columns of a CREATE INDEX statement, or of a PRIMARY KEY (...) or UNIQUE (...) constraint,
+// like a, b COLLATE NOCASE, c DESC
+func parseKeys(sql string) []key {
+	var keys []key
+	for _, def := range definitions(sql) {
+		k := key{name: firstWord(def)}
+		fields := strings.Fields(strings.ToUpper(def))
+		for i := 1; i < len(fields); i++ {
+			switch fields[i] {
+			case "DESC":
+				k.desc = true
+			case "COLLATE":
+				if i+1 < len(fields) {
+					k.collation = strings.Trim(fields[i+1], "\"'`[]")
+				}
+			}
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// collations returns the collations of the columns of a table that have one
+func (o Object) collations() map[string]string {
+	collations := map[string]string{}
+	for _, def := range columnDefs(o.SQL) {
+		fields := strings.Fields(strings.ToUpper(def))
+		for i := 1; i+1 < len(fields); i++ {
+			if fields[i] == "COLLATE" {
+				collations[firstWord(def)] = strings.Trim(fields[i+1], "\"'`[]")
+			}
+		}
+	}
+	return collations
+}
+
+// constraints returns the columns of the PRIMARY KEY and UNIQUE constraints of a table, that SQLite makes automatic indexes for, in order.
+// INTEGER PRIMARY KEY columns are aliases for the rowid and have no index, and constraints with the same columns as an earlier one share its index.
+func (o Object) constraints() [][]key {
+	var constraints [][]key
+	add := func(keys []key) {
+		for _, c := range constraints {
+			if sameColumns(c, keys) {
+				return
+			}
+		}
+		constraints = append(constraints, keys)
+	}
+
+	types := map[string]string{}
+	for _, def := range definitions(o.SQL) {
+		fields := strings.Fields(strings.ToUpper(def))
+
+		if !isConstraint(def) {
+			name := firstWord(def)
+			if len(fields) > 1 {
+				types[name] = fields[1]
+			}
+			for i := 1; i < len(fields); i++ {
+				switch {
+				case fields[i] == "PRIMARY" && i+1 < len(fields) && fields[i+1] == "KEY":
+					if types[name] == "INTEGER" {
+						continue
+					}
+					add([]key{{name: name, desc: i+2 < len(fields) && fields[i+2] == "DESC"}})
+				case fields[i] == "UNIQUE":
+					add([]key{{name: name}})
+				}
+			}
+			continue
+		}
+
+		if fields[0] == "CONSTRAINT" && len(fields) > 2 {
+			fields = fields[2:]
+		}
+		switch fields[0] {
+		case "PRIMARY":
+			keys := parseKeys(def)
+			if len(keys) == 1 && types[keys[0].name] == "INTEGER" && !keys[0].desc {
+				continue
+			}
+			add(keys)
+		case "UNIQUE":
+			add(parseKeys(def))
+		}
+	}
+
+	return constraints
+}
+
+// RowidAlias returns the index of the INTEGER PRIMARY KEY column of a table, or -1 if there is none.
+// Such a column is an alias for the rowid, and is stored as NULL in the record.
+func (o Object) RowidAlias() int {
+	for i, def := range columnDefs(o.SQL) {
+		fields := strings.Fields(strings.ToUpper(def))
+		if len(fields) >= 4 && fields[1] == "INTEGER" && fields[2] == "PRIMARY" && fields[3] == "KEY" {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// sameColumns reports if a and b have the same columns in the same order, with the same collations, which SQLite takes to be the same index whatever their sort order
+func sameColumns(a, b []key) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].name != b[i].name || a[i].collation != b[i].collation {
+			return false
+		}
+	}
+	return true
+}
+
+// splitTopLevel splits s on commas that are not inside parentheses or quotes
+func splitTopLevel(s string) []string {
+	var parts []string
+	depth := 0
+	var quote rune
+	last := 0
+
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '[':
+			quote = ']'
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == ',' && depth == 0:
+			parts = append(parts, strings.TrimSpace(s[last:i]))
+			last = i + 1
+		}
+	}
+
+	return append(parts, strings.TrimSpace(s[last:]))
+}
+
+// firstWord returns the first word of s, without any identifier quotes
+func firstWord(s string) string {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.Trim(fields[0], "\"'`[]")
+}
@@ -0,0 +1,619 @@
+package sqlite
+
+// Package sqlite is a minimal, read-only reader for SQLite database files.
+// It reads the file format directly (https://www.sqlite.org/fileformat2.html), and has no SQL engine.
+// It can list the schema, look up rows in tables by rowid, search indexes and scan tables,
+// which is what is needed to read tiles from archives like MBTiles without cgo.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// b-tree page types
+const (
+	interiorIndex = 2
+	interiorTable = 5
+	leafIndex     = 10
+	leafTable     = 13
+)
+
+// header is the magic string that all SQLite database files start with
+var header = []byte("SQLite format 3\x00")
+
+// DB is a read-only SQLite database file
+type DB struct {
+	r        io.ReaderAt
+	closer   io.Closer
+	pageSize int
+	usable   int
+}
+
+// Open opens the SQLite database file at path
+func Open(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := New(f)
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "could not open %s", path)
+	}
+	db.closer = f
+
+	return db, nil
+}
+
+// New returns a DB that reads the database from r
+func New(r io.ReaderAt) (*DB, error) {
+	h := make([]byte, 100)
+	_, err := r.ReadAt(h, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read header")
+	}
+
+	if !bytes.Equal(h[:16], header) {
+		return nil, errors.New("not a SQLite database")
+	}
+
+	db := DB{r: r}
+
+	db.pageSize = int(binary.BigEndian.Uint16(h[16:18]))
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	db.usable = db.pageSize - int(h[20])
+	if db.pageSize < 512 || db.pageSize&(db.pageSize-1) != 0 || db.usable < 480 {
+		return nil, fmt.Errorf("invalid page size %d with %d usable bytes", db.pageSize, db.usable)
+	}
+
+	if encoding := binary.BigEndian.Uint32(h[56:60]); encoding > 1 {
+		return nil, fmt.Errorf("unsupported text encoding %d, only UTF-8 is supported", encoding)
+	}
+
+	return &db, nil
+}
+
+// Close closes the database file
+func (db *DB) Close() error {
+	if db.closer == nil {
+		return nil
+	}
+	return db.closer.Close()
+}
+
+// page reads page number n. Pages are numbered from 1.
+func (db *DB) page(n int) ([]byte, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("invalid page number %d", n)
+	}
+
+	p := make([]byte, db.pageSize)
+	_, err := db.r.ReadAt(p, int64(n-1)*int64(db.pageSize))
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrapf(err, "could not read page %d", n)
+	}
+
+	return p, nil
+}
+
+// maxDepth is the deepest a b-tree is followed. Real b-trees are far shallower, so deeper ones are corrupt, e.g. with a page that points back to itself.
+const maxDepth = 64
+
+// errCorrupt is returned, wrapped, for database files with offsets or sizes that are out of bounds
+var errCorrupt = errors.New("corrupt database")
+
+// btreePage is a parsed b-tree page
+type btreePage struct {
+	data  []byte
+	typ   byte
+	cells []int // offsets of the cells
+	right int   // right most child page, for interior pages
+}
+
+// btree reads page n as a b-tree page
+func (db *DB) btree(n int) (*btreePage, error) {
+	data, err := db.page(n)
+	if err != nil {
+		return nil, err
+	}
+
+	// page 1 has the database header before the b-tree header
+	offset := 0
+	if n == 1 {
+		offset = 100
+	}
+
+	p := btreePage{
+		data: data,
+		typ:  data[offset],
+	}
+
+	headerSize := 8
+	switch p.typ {
+	case interiorIndex, interiorTable:
+		headerSize = 12
+		p.right = int(binary.BigEndian.Uint32(data[offset+8:]))
+	case leafIndex, leafTable:
+	default:
+		return nil, fmt.Errorf("page %d is not a b-tree page", n)
+	}
+
+	// the cells must be after the cell pointers, and interior cells start with the 4 byte page number of their left child
+	count := int(binary.BigEndian.Uint16(data[offset+3:]))
+	start := offset + headerSize + 2*count
+	if start > db.usable {
+		return nil, errors.Wrapf(errCorrupt, "page %d has %d cells, which do not fit", n, count)
+	}
+
+	p.cells = make([]int, count)
+	for i := range p.cells {
+		c := int(binary.BigEndian.Uint16(data[offset+headerSize+2*i:]))
+		if c < start || c+4 > db.usable {
+			return nil, errors.Wrapf(errCorrupt, "cell %d of page %d is at %d, outside the page", i, n, c)
+		}
+		p.cells[i] = c
+	}
+
+	return &p, nil
+}
+
+// payload reads a cell payload of size bytes starting at offset of page p, including any overflow pages.
+func (db *DB) payload(p *btreePage, offset int, size int64) ([]byte, error) {
+	// See "Cell Payload Overflow Pages" in the file format docs for the calculation of local payload size
+	if size < 0 || size > maxPayload {
+		return nil, errors.Wrapf(errCorrupt, "cell payload of %d bytes", size)
+	}
+
+	u := int64(db.usable)
+	maxLocal := u - 35
+	if p.typ != leafTable {
+		maxLocal = (u-12)*64/255 - 23
+	}
+
+	if size <= maxLocal {
+		if offset+int(size) > db.usable {
+			return nil, errors.Wrap(errCorrupt, "cell payload goes past the end of the page")
+		}
+		return p.data[offset : offset+int(size)], nil
+	}
+
+	minLocal := (u-12)*32/255 - 23
+	local := minLocal + (size-minLocal)%(u-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+	if offset+int(local)+4 > db.usable {
+		return nil, errors.Wrap(errCorrupt, "cell payload goes past the end of the page")
+	}
+
+	// the payload grows as the overflow pages are read, rather than trusting size, which is wrong in corrupt files
+	payload := append([]byte(nil), p.data[offset:offset+int(local)]...)
+
+	next := int(binary.BigEndian.Uint32(p.data[offset+int(local):]))
+	for int64(len(payload)) < size {
+		if next == 0 {
+			return nil, errors.New("overflow chain ended early")
+		}
+
+		overflow, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+
+		next = int(binary.BigEndian.Uint32(overflow))
+		n := int64(db.usable - 4)
+		if remaining := size - int64(len(payload)); remaining < n {
+			n = remaining
+		}
+		payload = append(payload, overflow[4:4+n]...)
+	}
+
+	return payload, nil
+}
+
+// maxPayload is the largest payload of a cell, which is the largest row SQLite allows
+const maxPayload = 1 << 30
+
+// tableCell returns the rowid and values of a cell in a table leaf page
+func (db *DB) tableCell(p *btreePage, cell int) (int64, []interface{}, error) {
+	offset := p.cells[cell]
+
+	size, n := varint(p.data[offset:db.usable])
+	offset += n
+	rowid, m := varint(p.data[offset:db.usable])
+	offset += m
+	if n == 0 || m == 0 {
+		return 0, nil, errors.Wrap(errCorrupt, "cell header goes past the end of the page")
+	}
+
+	payload, err := db.payload(p, offset, size)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	values, err := record(payload)
+	return rowid, values, err
+}
+
+// indexCell returns the values of a cell in an index page
+func (db *DB) indexCell(p *btreePage, cell int) ([]interface{}, error) {
+	offset := p.cells[cell]
+	if p.typ == interiorIndex {
+		offset += 4
+	}
+
+	size, n := varint(p.data[offset:db.usable])
+	if n == 0 {
+		return nil, errors.Wrap(errCorrupt, "cell header goes past the end of the page")
+	}
+	offset += n
+
+	payload, err := db.payload(p, offset, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return record(payload)
+}
+
+// child returns the left child page of a cell in an interior page
+func (p *btreePage) child(cell int) int {
+	return int(binary.BigEndian.Uint32(p.data[p.cells[cell]:]))
+}
+
+// Row returns the values of the row with rowid in the table with root page root.
+// The bool is false if there is no such row.
+func (db *DB) Row(root int, rowid int64) ([]interface{}, bool, error) {
+	n := root
+	for depth := 0; ; depth++ {
+		if depth > maxDepth {
+			return nil, false, errors.Wrapf(errCorrupt, "table b-tree %d is more than %d pages deep", root, maxDepth)
+		}
+
+		p, err := db.btree(n)
+		if err != nil {
+			return nil, false, err
+		}
+
+		switch p.typ {
+		case interiorTable:
+			// cells are sorted by key, and the left child of a cell has all rowids <= key
+			n = p.right
+			for i := range p.cells {
+				key, m := varint(p.data[p.cells[i]+4 : db.usable])
+				if m == 0 {
+					return nil, false, errors.Wrapf(errCorrupt, "cell %d of page %d goes past the end of the page", i, n)
+				}
+				if rowid <= key {
+					n = p.child(i)
+					break
+				}
+			}
+		case leafTable:
+			for i := range p.cells {
+				// the rowid comes after the payload size, and is checked before the payload is read
+				_, m := varint(p.data[p.cells[i]:db.usable])
+				if id, _ := varint(p.data[p.cells[i]+m : db.usable]); id != rowid {
+					continue
+				}
+
+				_, values, err := db.tableCell(p, i)
+				if err != nil {
+					return nil, false, err
+				}
+				return values, true, nil
+			}
+			return nil, false, nil
+		default:
+			return nil, false, fmt.Errorf("page %d is not a table b-tree page", n)
+		}
+	}
+}
+
+// Scan calls fn for each row in the table with root page root, in rowid order.
+// Scanning stops if fn returns false.
+func (db *DB) Scan(root int, fn func(rowid int64, values []interface{}) bool) error {
+	_, err := db.scan(root, 0, fn)
+	return err
+}
+
+// scan is the recursive part of Scan, for page n at depth in the b-tree. The bool is false if scanning should stop.
+func (db *DB) scan(n, depth int, fn func(rowid int64, values []interface{}) bool) (bool, error) {
+	if depth > maxDepth {
+		return false, errors.Wrapf(errCorrupt, "table b-tree is more than %d pages deep at page %d", maxDepth, n)
+	}
+
+	p, err := db.btree(n)
+	if err != nil {
+		return false, err
+	}
+
+	switch p.typ {
+	case interiorTable:
+		for i := range p.cells {
+			more, err := db.scan(p.child(i), depth+1, fn)
+			if err != nil || !more {
+				return more, err
+			}
+		}
+		return db.scan(p.right, depth+1, fn)
+	case leafTable:
+		for i := range p.cells {
+			rowid, values, err := db.tableCell(p, i)
+			if err != nil {
+				return false, err
+			}
+			if !fn(rowid, values) {
+				return false, nil
+			}
+		}
+		return true, nil
+	default:
+		return false, fmt.Errorf("page %d is not a table b-tree page", n)
+	}
+}
+
+// Lookup searches the index with root page root for the first entry where the leading columns are equal to key.
+// The values of the index entry is returned, where the last value is the rowid of the row in the table.
+// The bool is false if there is no such entry. The columns must be in ascending order, and text is compared with the BINARY collation,
+// see LookupIndex for other indexes.
+func (db *DB) Lookup(root int, key ...interface{}) ([]interface{}, bool, error) {
+	return db.lookup(root, nil, key)
+}
+
+// LookupIndex is like Lookup, but for the index o from Schema, which may have columns in descending order.
+// Text can only be looked up in columns with the BINARY collation, as others sort text differently, and an error is returned for other collations.
+// An error is returned for partial indexes too, see Object.Partial.
+func (db *DB) LookupIndex(o Object, key ...interface{}) ([]interface{}, bool, error) {
+	if o.Type != "index" || len(key) > len(o.keys) {
+		return nil, false, fmt.Errorf("can not look up %d columns in %s %s", len(key), o.Type, o.Name)
+	}
+	if o.Partial() {
+		return nil, false, fmt.Errorf("can not look up rows in the partial index %s", o.Name)
+	}
+
+	desc := make([]bool, len(key))
+	for i := range key {
+		k := o.keys[i]
+		if _, ok := key[i].(string); ok && k.collation != "" && k.collation != "BINARY" {
+			return nil, false, fmt.Errorf("can not look up text in column %s of index %s, which has the %s collation", k.name, o.Name, k.collation)
+		}
+		desc[i] = k.desc
+	}
+
+	return db.lookup(o.RootPage, desc, key)
+}
+
+// lookup does the work of Lookup, for an index where the columns in desc are in descending order
+func (db *DB) lookup(root int, desc []bool, key []interface{}) ([]interface{}, bool, error) {
+	n := root
+	for depth := 0; ; depth++ {
+		if depth > maxDepth {
+			return nil, false, errors.Wrapf(errCorrupt, "index b-tree %d is more than %d pages deep", root, maxDepth)
+		}
+
+		p, err := db.btree(n)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if p.typ != interiorIndex && p.typ != leafIndex {
+			return nil, false, fmt.Errorf("page %d is not an index b-tree page", n)
+		}
+
+		// find the first cell that is >= key
+		next := p.right
+		for i := range p.cells {
+			values, err := db.indexCell(p, i)
+			if err != nil {
+				return nil, false, err
+			}
+
+			c := compareKey(values, key, desc)
+			if c == 0 {
+				// interior cells are entries too
+				return values, true, nil
+			}
+			if c > 0 {
+				if p.typ == leafIndex {
+					return nil, false, nil
+				}
+				next = p.child(i)
+				break
+			}
+		}
+
+		if p.typ == leafIndex {
+			return nil, false, nil
+		}
+		n = next
+	}
+}
+
+// compareKey compares the leading values of an index entry with key, in the order of the index, where the columns in desc are in descending order
+func compareKey(values []interface{}, key []interface{}, desc []bool) int {
+	for i := range key {
+		if i >= len(values) {
+			return -1
+		}
+		if c := Compare(values[i], key[i]); c != 0 {
+			if i < len(desc) && desc[i] {
+				return -c
+			}
+			return c
+		}
+	}
+	return 0
+}
+
+// Compare compares two values in SQLite sort order: NULL < numbers < text < blobs.
+// Values are equal if it returns 0, which, unlike ==, works for blobs, and for integers and reals of the same value.
+func Compare(a, b interface{}) int {
+	ca, cb := class(a), class(b)
+	if ca != cb {
+		if ca < cb {
+			return -1
+		}
+		return 1
+	}
+
+	switch a := a.(type) {
+	case int64:
+		if b, ok := b.(int64); ok {
+			// compared as ints, to not lose precision on large values
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			}
+			return 0
+		}
+		return compareFloat(float64(a), toFloat(b))
+	case float64:
+		return compareFloat(a, toFloat(b))
+	case string:
+		return bytes.Compare([]byte(a), []byte(b.(string)))
+	case []byte:
+		return bytes.Compare(a, b.([]byte))
+	}
+
+	return 0
+}
+
+// compareFloat compares two floats
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// class returns the sort class of a value
+func class(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case int64, float64:
+		return 1
+	case string:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// toFloat converts a numeric value to float64
+func toFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+// record decodes a record into its values.
+// Values are nil, int64, float64, string or []byte.
+func record(payload []byte) ([]interface{}, error) {
+	headerSize, n := varint(payload)
+	if headerSize > int64(len(payload)) || headerSize < int64(n) || n == 0 {
+		return nil, errors.New("corrupt record header")
+	}
+
+	var types []int64
+	for offset := n; offset < int(headerSize); {
+		t, n := varint(payload[offset:])
+		if n == 0 {
+			return nil, errors.New("corrupt record header")
+		}
+		types = append(types, t)
+		offset += n
+	}
+
+	values := make([]interface{}, len(types))
+	body := payload[headerSize:]
+	for i, t := range types {
+		size := serialSize(t)
+		if size < 0 || size > len(body) {
+			return nil, errors.New("corrupt record")
+		}
+		values[i] = value(t, body[:size])
+		body = body[size:]
+	}
+
+	return values, nil
+}
+
+// serialSize returns the size in bytes of a value of serial type t
+func serialSize(t int64) int {
+	switch {
+	case t >= 12:
+		return int((t - 12) / 2)
+	case t == 5:
+		return 6
+	case t == 6, t == 7:
+		return 8
+	case t >= 1 && t <= 4:
+		return int(t)
+	}
+	return 0
+}
+
+// value decodes a value of serial type t
+func value(t int64, data []byte) interface{} {
+	switch {
+	case t == 0:
+		return nil
+	case t >= 1 && t <= 6:
+		// big-endian twos-complement integers of 1, 2, 3, 4, 6 or 8 bytes
+		v := int64(int8(data[0]))
+		for _, b := range data[1:] {
+			v = v<<8 | int64(b)
+		}
+		return v
+	case t == 7:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	case t == 8:
+		return int64(0)
+	case t == 9:
+		return int64(1)
+	case t >= 12 && t%2 == 0:
+		return append([]byte(nil), data...)
+	case t >= 13:
+		return string(data)
+	}
+	return nil
+}
+
+// varint decodes a SQLite varint, and returns the value and the number of bytes read.
+// Zero bytes read means that b is too short.
+func varint(b []byte) (int64, int) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		if i == 8 {
+			return int64(v<<8 | uint64(b[i])), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return int64(v), i + 1
+		}
+	}
+	return int64(v), 9
+}
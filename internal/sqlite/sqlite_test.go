@@ -0,0 +1,360 @@
+package sqlite
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"testing"
+)
+
+// testdata/test.db is created with python like this:
+//
+//	db.execute('PRAGMA page_size=1024')
+//	db.execute('CREATE TABLE "things" (id INTEGER PRIMARY KEY, name TEXT NOT NULL, n INTEGER, f REAL, data BLOB, UNIQUE (name))')
+//	db.execute('CREATE INDEX things_n ON things (n, f)')
+//	for i in range(1,1001):
+//	    data = bytes((i*j) % 256 for j in range(5000 if i % 100 == 0 else 10))
+//	    db.execute('INSERT INTO things VALUES (?,?,?,?,?)',(i,'thing-%04d'%i,i*1000-500000 if i!=7 else None,i/4,data))
+
+// thingData returns the expected data blob of thing i
+func thingData(i int) []byte {
+	n := 10
+	if i%100 == 0 {
+		n = 5000
+	}
+	data := make([]byte, n)
+	for j := range data {
+		data[j] = byte((i * j) % 256)
+	}
+	return data
+}
+
+// openTest opens testdata/test.db and returns it along with its schema objects by name
+func openTest(t *testing.T) (*DB, map[string]Object) {
+	db, err := Open("testdata/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := db.Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects := map[string]Object{}
+	for _, o := range schema {
+		objects[o.Name] = o
+	}
+
+	return db, objects
+}
+
+func TestSchema(t *testing.T) {
+	db, objects := openTest(t)
+	defer db.Close()
+
+	things, ok := objects["things"]
+	if !ok || things.Type != "table" {
+		t.Fatalf("table things not found in %+v", objects)
+	}
+
+	if got, want := fmt.Sprint(things.Columns()), "[id name n f data]"; got != want {
+		t.Errorf("columns: got %s - want %s", got, want)
+	}
+
+	if got := things.RowidAlias(); got != 0 {
+		t.Errorf("rowid alias: got %d - want 0", got)
+	}
+
+	index, ok := objects["things_n"]
+	if !ok || index.Type != "index" || index.Table != "things" {
+		t.Fatalf("index things_n not found in %+v", objects)
+	}
+
+	if got, want := fmt.Sprint(index.Columns()), "[n f]"; got != want {
+		t.Errorf("index columns: got %s - want %s", got, want)
+	}
+}
+
+func TestRow(t *testing.T) {
+	db, objects := openTest(t)
+	defer db.Close()
+
+	for _, i := range []int{1, 7, 100, 555, 1000} {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			values, ok, err := db.Row(objects["things"].RootPage, int64(i))
+			if err != nil || !ok {
+				t.Fatalf("got %v,%v - want row", ok, err)
+			}
+
+			var n interface{} = int64(i*1000 - 500000)
+			if i == 7 {
+				n = nil
+			}
+
+			// REAL values without a fractional part are stored as integers
+			if values[0] != nil || values[1] != fmt.Sprintf("thing-%04d", i) || values[2] != n || toFloat(values[3]) != float64(i)/4 {
+				t.Errorf("got %v", values[:4])
+			}
+
+			if !bytes.Equal(values[4].([]byte), thingData(i)) {
+				t.Errorf("got wrong data of length %d", len(values[4].([]byte)))
+			}
+		})
+	}
+
+	_, ok, err := db.Row(objects["things"].RootPage, 1001)
+	if ok || err != nil {
+		t.Errorf("got %v,%v for missing row - want false,nil", ok, err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	db, objects := openTest(t)
+	defer db.Close()
+
+	var lookupTest = []struct {
+		index string
+		key   []interface{}
+		rowid int64
+		found bool
+	}{
+		{"sqlite_autoindex_things_1", []interface{}{"thing-0001"}, 1, true},
+		{"sqlite_autoindex_things_1", []interface{}{"thing-0777"}, 777, true},
+		{"sqlite_autoindex_things_1", []interface{}{"thing-1000"}, 1000, true},
+		{"sqlite_autoindex_things_1", []interface{}{"thing-9999"}, 0, false},
+		{"things_n", []interface{}{int64(-499000)}, 1, true},
+		{"things_n", []interface{}{int64(0), float64(125)}, 500, true},
+		{"things_n", []interface{}{nil}, 7, true},
+		{"things_n", []interface{}{int64(1)}, 0, false},
+	}
+
+	for _, test := range lookupTest {
+		t.Run(fmt.Sprint(test.key...), func(t *testing.T) {
+			values, ok, err := db.Lookup(objects[test.index].RootPage, test.key...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ok != test.found {
+				t.Fatalf("got found %v - want %v", ok, test.found)
+			}
+
+			if ok && values[len(values)-1] != test.rowid {
+				t.Errorf("got rowid %v - want %d", values[len(values)-1], test.rowid)
+			}
+		})
+	}
+}
+
+func TestScan(t *testing.T) {
+	db, objects := openTest(t)
+	defer db.Close()
+
+	count := 0
+	last := int64(0)
+	err := db.Scan(objects["things"].RootPage, func(rowid int64, values []interface{}) bool {
+		count++
+		if rowid <= last {
+			t.Errorf("got rowid %d after %d", rowid, last)
+		}
+		last = rowid
+		return true
+	})
+
+	if err != nil || count != 1000 {
+		t.Errorf("got %d rows and error '%v' - want 1000 rows", count, err)
+	}
+}
+
+func TestVarint(t *testing.T) {
+	var varintTest = []struct {
+		in       []byte
+		expected int64
+		n        int
+	}{
+		{[]byte{0x7f}, 127, 1},
+		{[]byte{0x81, 0x00}, 128, 2},
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, -1, 9},
+		{[]byte{0x81}, 0, 0},
+	}
+
+	for _, test := range varintTest {
+		t.Run(fmt.Sprintf("%x", test.in), func(t *testing.T) {
+			v, n := varint(test.in)
+			if v != test.expected || n != test.n {
+				t.Errorf("got %d,%d - want %d,%d", v, n, test.expected, test.n)
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	var compareTest = []struct {
+		a, b     interface{}
+		expected int
+	}{
+		{int64(math.MaxInt64), int64(-1), 1},
+		{int64(math.MinInt64), int64(1), -1},
+		{int64(math.MaxInt64), int64(math.MaxInt64 - 1), 1},
+		{int64(3), int64(3), 0},
+		{int64(3), 3.0, 0},
+		{2.5, int64(3), -1},
+		{nil, int64(1), -1},
+		{int64(1), "a", -1},
+		{"b", "a", 1},
+		{[]byte("a"), "b", 1},
+		{[]byte("a"), []byte("a"), 0},
+	}
+
+	for _, test := range compareTest {
+		t.Run(fmt.Sprintf("%v,%v", test.a, test.b), func(t *testing.T) {
+			if got := Compare(test.a, test.b); got != test.expected {
+				t.Errorf("got %d - want %d", got, test.expected)
+			}
+		})
+	}
+}
+
+// testdata/index.db has automatic indexes, and columns in descending order and with other collations. It is created with python like this:
+//
+//	db.execute('PRAGMA page_size=1024')
+//	db.execute('CREATE TABLE pk (a INTEGER, b TEXT, c BLOB, PRIMARY KEY (a, b))')
+//	db.execute('CREATE TABLE cols (id INTEGER PRIMARY KEY, name TEXT UNIQUE, code TEXT COLLATE NOCASE UNIQUE, n INTEGER, UNIQUE (n DESC), UNIQUE (name))')
+//	db.execute('CREATE INDEX cols_n ON cols (n DESC, name)')
+//	for i in range(1, 501):
+//	    db.execute('INSERT INTO pk VALUES (?,?,?)', (i % 50, 'b-%04d' % i, bytes([i % 256]) * 20))
+//	    db.execute('INSERT INTO cols VALUES (?,?,?,?)', (i, 'name-%04d' % i, 'Code-%04d' % i, i * 10))
+
+func TestIndexes(t *testing.T) {
+	db, err := Open("testdata/index.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	schema, err := db.Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the keys are as listed by PRAGMA index_xinfo
+	want := map[string][]key{
+		"sqlite_autoindex_pk_1":   {{"a", false, ""}, {"b", false, ""}},
+		"sqlite_autoindex_cols_1": {{"name", false, ""}},
+		"sqlite_autoindex_cols_2": {{"code", false, "NOCASE"}},
+		"sqlite_autoindex_cols_3": {{"n", true, ""}},
+		"cols_n":                  {{"n", true, ""}, {"name", false, ""}},
+	}
+	objects := map[string]Object{}
+	for _, o := range schema {
+		objects[o.Name] = o
+		if o.Type == "index" && fmt.Sprint(o.keys) != fmt.Sprint(want[o.Name]) {
+			t.Errorf("index %s: got keys %v - want %v", o.Name, o.keys, want[o.Name])
+		}
+	}
+	if len(objects) != 7 {
+		t.Errorf("got %d objects - want 7", len(objects))
+	}
+
+	var lookupTest = []struct {
+		index string
+		key   []interface{}
+		rowid int64
+		found bool
+		err   bool
+	}{
+		{"sqlite_autoindex_pk_1", []interface{}{int64(7), "b-0257"}, 257, true, false},
+		{"sqlite_autoindex_pk_1", []interface{}{int64(7), "b-0258"}, 0, false, false},
+		{"sqlite_autoindex_cols_1", []interface{}{"name-0321"}, 321, true, false},
+		{"sqlite_autoindex_cols_3", []interface{}{int64(10)}, 1, true, false},
+		{"sqlite_autoindex_cols_3", []interface{}{int64(2500)}, 250, true, false},
+		{"sqlite_autoindex_cols_3", []interface{}{int64(5000)}, 500, true, false},
+		{"sqlite_autoindex_cols_3", []interface{}{int64(2505)}, 0, false, false},
+		{"cols_n", []interface{}{int64(4440), "name-0444"}, 444, true, false},
+		{"cols_n", []interface{}{int64(4440), "name-0445"}, 0, false, false},
+		{"sqlite_autoindex_cols_2", []interface{}{"code-0001"}, 0, false, true},
+		{"cols_n", []interface{}{int64(1), "a", "b"}, 0, false, true},
+	}
+
+	for _, test := range lookupTest {
+		t.Run(test.index+fmt.Sprint(test.key...), func(t *testing.T) {
+			values, ok, err := db.LookupIndex(objects[test.index], test.key...)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v - want error %t", err, test.err)
+			}
+			if ok != test.found {
+				t.Fatalf("got found %v - want %v", ok, test.found)
+			}
+			if ok && values[len(values)-1] != test.rowid {
+				t.Errorf("got rowid %v - want %d", values[len(values)-1], test.rowid)
+			}
+		})
+	}
+}
+
+func TestPartial(t *testing.T) {
+	var partialTest = []struct {
+		sql     string
+		keys    []key
+		partial bool
+	}{
+		{"CREATE UNIQUE INDEX i ON tiles (zoom_level, tile_column, tile_row)", []key{{"zoom_level", false, ""}, {"tile_column", false, ""}, {"tile_row", false, ""}}, false},
+		{"CREATE UNIQUE INDEX i ON tiles (zoom_level, tile_column, tile_row) WHERE zoom_level IN (1,2)", []key{{"zoom_level", false, ""}, {"tile_column", false, ""}, {"tile_row", false, ""}}, true},
+		{"CREATE INDEX i ON t (a DESC, \"b)\" COLLATE NOCASE)\nwhere (a > 0)", []key{{"a", true, ""}, {"b)", false, "NOCASE"}}, true},
+		{"CREATE INDEX \"where\" ON t (a)", []key{{"a", false, ""}}, false},
+	}
+
+	for _, test := range partialTest {
+		t.Run(test.sql, func(t *testing.T) {
+			o := Object{Type: "index", SQL: test.sql}
+			if got := parseKeys(o.SQL); fmt.Sprint(got) != fmt.Sprint(test.keys) {
+				t.Errorf("got keys %v - want %v", got, test.keys)
+			}
+			if got := o.Partial(); got != test.partial {
+				t.Errorf("got partial %t - want %t", got, test.partial)
+			}
+		})
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	good, err := ioutil.ReadFile("testdata/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, objects := openTest(t)
+	db.Close()
+
+	// bytes all over the first pages, which have the schema, and the b-tree headers of the other pages are broken in turn.
+	// Reading the database must give errors, or wrong values, but never panic.
+	pageSize := 1024
+	for offset := 0; offset < len(good); offset += 5 {
+		if offset >= 3*pageSize && offset%pageSize >= 16 {
+			continue
+		}
+		for _, b := range []byte{0x00, 0xff} {
+			data := append([]byte(nil), good...)
+			data[offset] = b
+
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Fatalf("got panic with byte %d set to %#x: %v", offset, b, r)
+					}
+				}()
+
+				db, err := New(bytes.NewReader(data))
+				if err != nil {
+					return
+				}
+				db.Schema()
+				db.Scan(objects["things"].RootPage, func(int64, []interface{}) bool { return true })
+				db.Row(objects["things"].RootPage, 500)
+				db.LookupIndex(objects["things_n"], int64(0))
+				db.Lookup(objects["sqlite_autoindex_things_1"].RootPage, "thing-0777")
+			}()
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package stitch
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Polygon is an area on the map, e.g. a property, which may have holes
+type Polygon struct {
+	// Rings are the outline of the polygon and then its holes, each as lat and long
+	Rings [][][2]float64
+	// Fill is the color inside the polygon, with the opacity as alpha
+	Fill color.NRGBA
+	// Color is the color of the outline, with the opacity as alpha
+	Color color.NRGBA
+	// Width is the width of the outline in pixels, at scale 1. Zero means no outline.
+	Width float64
+}
+
+// Circle is a circle on the map, e.g. a search radius
+type Circle struct {
+	Lat  float64
+	Long float64
+	// Radius is in meters
+	Radius float64
+	// Fill is the color inside the circle, with the opacity as alpha
+	Fill color.NRGBA
+	// Color is the color of the outline, with the opacity as alpha
+	Color color.NRGBA
+	// Width is the width of the outline in pixels, at scale 1. Zero means no outline.
+	Width float64
+}
+
+// Limits of polygons and circles
+const (
+	maxPolygonPoints = 10000
+	maxCircles       = 256
+	// maxCircleRadius is a quarter of the circumference of the earth, in meters
+	maxCircleRadius = 1e7
+)
+
+// earthRadius is the radius of the earth in meters, as used by the web mercator projection
+const earthRadius = 6378137
+
+// defaultAreaFill is the fill of polygons and circles that do not have one
+var defaultAreaFill = color.NRGBA{0x1e, 0x64, 0xd2, 0x40}
+
+// defaultAreaWidth is the width of the outline of polygons and circles that do not have one
+const defaultAreaWidth = 2
+
+// areaStyle is the fill and outline of a polygon or circle, while it is parsed
+type areaStyle struct {
+	fill, color          color.NRGBA
+	width                float64
+	opacity, fillOpacity float64
+}
+
+// newAreaStyle returns the default style of polygons and circles
+func newAreaStyle() areaStyle {
+	return areaStyle{fill: defaultAreaFill, color: defaultPathColor, width: defaultAreaWidth, opacity: 1, fillOpacity: 1}
+}
+
+// parse parses the style key with value into s, and returns false if key is not a style of areas
+func (s *areaStyle) parse(key, value string) (bool, error) {
+	var err error
+	switch key {
+	case "color":
+		s.color, err = parseColor(value)
+	case "width":
+		s.width, err = parseWidth(value, true)
+	case "opacity":
+		s.opacity, err = parseOpacity(value)
+	case "fillcolor":
+		s.fill, err = parseColor(value)
+	case "fillopacity":
+		s.fillOpacity, err = parseOpacity(value)
+	default:
+		return false, nil
+	}
+	return true, err
+}
+
+// ParsePolygons parses polygons, like fillcolor:green|fillopacity:0.3|59.92,10.77|59.93,10.78|59.95,10.76, with the lat,long of the outline in order.
+// The item hole starts a hole in the polygon, like 59.92,10.77|59.93,10.78|59.95,10.76|hole|59.93,10.77|59.935,10.775|59.94,10.77.
+// The points of the last ring can also be a Google encoded polyline, like enc:_p~iF~ps|U_ulLnnqC_mqNvxq`@, which must be last, as it can have | in it.
+// The styles of a polygon are:
+//
+//	color        the color of the outline, see parseColor
+//	width        the width of the outline in pixels, 2 by default, or 0 for no outline
+//	opacity      from 0 to 1, which is multiplied with the alpha of the color
+//	fillcolor    the color inside the polygon, the color of paths a quarter opaque by default
+//	fillopacity  from 0 to 1, which is multiplied with the alpha of the fillcolor
+func ParsePolygons(groups []string) ([]Polygon, error) {
+	var polygons []Polygon
+	total := 0
+
+	for _, group := range groups {
+		style := newAreaStyle()
+		rings := [][][2]float64{nil}
+
+		items := strings.Split(group, "|")
+		for i := 0; i < len(items); i++ {
+			item := strings.TrimSpace(items[i])
+			if item == "" {
+				continue
+			}
+
+			if strings.ToLower(item) == "hole" {
+				rings = append(rings, nil)
+				continue
+			}
+			last := len(rings) - 1
+
+			if strings.HasPrefix(item, "enc:") {
+				// encoded polylines can have | in them, so the rest of the group is the polyline
+				enc := strings.TrimPrefix(strings.TrimSpace(strings.Join(items[i:], "|")), "enc:")
+				points, err := decodePolyline(enc)
+				if err != nil {
+					return nil, err
+				}
+				rings[last] = append(rings[last], points...)
+				break
+			}
+
+			j := strings.Index(item, ":")
+			if j < 0 {
+				lat, long, err := parseLocation(item)
+				if err != nil {
+					return nil, err
+				}
+				rings[last] = append(rings[last], [2]float64{lat, long})
+				continue
+			}
+
+			key, value := strings.ToLower(item[:j]), strings.TrimSpace(item[j+1:])
+			ok, err := style.parse(key, value)
+			if !ok {
+				err = fmt.Errorf("unknown polygon style '%s', use color, width, opacity, fillcolor or fillopacity", key)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for i, ring := range rings {
+			if len(ring) < 3 {
+				if i == 0 {
+					return nil, fmt.Errorf("polygon '%s' has less than 3 lat,long", group)
+				}
+				return nil, fmt.Errorf("hole %d of polygon '%s' has less than 3 lat,long", i, group)
+			}
+			total += len(ring)
+		}
+		if total > maxPolygonPoints {
+			return nil, fmt.Errorf("polygons have more than the %d points allowed", maxPolygonPoints)
+		}
+
+		polygons = append(polygons, Polygon{
+			Rings: rings,
+			Fill:  withOpacity(style.fill, style.fillOpacity),
+			Color: withOpacity(style.color, style.opacity),
+			Width: style.width,
+		})
+	}
+
+	return polygons, nil
+}
+
+// ParseCircles parses circles, like radius:500|fillcolor:red|59.92,10.77|59.93,10.78, around each lat,long.
+// The radius in meters is required, and the other styles are those of polygons, see ParsePolygons.
+func ParseCircles(groups []string) ([]Circle, error) {
+	var circles []Circle
+
+	for _, group := range groups {
+		style := newAreaStyle()
+		radius := 0.0
+		var centers [][2]float64
+
+		for _, item := range strings.Split(group, "|") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+
+			j := strings.Index(item, ":")
+			if j < 0 {
+				lat, long, err := parseLocation(item)
+				if err != nil {
+					return nil, err
+				}
+				centers = append(centers, [2]float64{lat, long})
+				continue
+			}
+
+			key, value := strings.ToLower(item[:j]), strings.TrimSpace(item[j+1:])
+			ok, err := style.parse(key, value)
+			if !ok && key == "radius" {
+				radius, err = parseRadius(value)
+			} else if !ok {
+				err = fmt.Errorf("unknown circle style '%s', use radius, color, width, opacity, fillcolor or fillopacity", key)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(centers) == 0 {
+			return nil, fmt.Errorf("circle '%s' has no lat,long", group)
+		}
+		if radius == 0 {
+			return nil, fmt.Errorf("circle '%s' has no radius", group)
+		}
+
+		for _, c := range centers {
+			circles = append(circles, Circle{
+				Lat:    c[0],
+				Long:   c[1],
+				Radius: radius,
+				Fill:   withOpacity(style.fill, style.fillOpacity),
+				Color:  withOpacity(style.color, style.opacity),
+				Width:  style.width,
+			})
+		}
+	}
+
+	if len(circles) > maxCircles {
+		return nil, fmt.Errorf("there are %d circles, which is more than the %d allowed", len(circles), maxCircles)
+	}
+
+	return circles, nil
+}
+
+// parseRadius parses the radius of a circle in meters
+func parseRadius(s string) (float64, error) {
+	radius, err := strconv.ParseFloat(s, 64)
+	if err != nil || !(radius > 0 && radius <= maxCircleRadius) {
+		return 0, fmt.Errorf("radius '%s' is not a number of meters above 0 and up to %g", s, float64(maxCircleRadius))
+	}
+	return radius, nil
+}
+
+// drawAreas draws polygons and then circles on img, a map with pixel density scale, where pixel converts lat and long to pixels of img
+func drawAreas(img *image.RGBA, polygons []Polygon, circles []Circle, scale int, pixel func(lat, long float64) (float64, float64)) {
+	for _, p := range polygons {
+		rings := make([][][2]float64, len(p.Rings))
+		for i, ring := range p.Rings {
+			rings[i] = make([][2]float64, len(ring))
+			for j, point := range ring {
+				rings[i][j][0], rings[i][j][1] = pixel(point[0], point[1])
+			}
+		}
+		drawArea(img, rings, p.Fill, p.Color, p.Width*float64(scale))
+	}
+
+	for _, c := range circles {
+		cx, cy := pixel(c.Lat, c.Long)
+		rx, ry := circleRadius(c, pixel)
+		if !(rx > 0 && ry > 0) || math.IsInf(rx, 0) || math.IsInf(ry, 0) {
+			continue
+		}
+
+		// the circle is a polygon with points about 3 pixels apart, which is as smooth as a curve after anti-aliasing
+		n := int(math.Ceil(2 * math.Pi * math.Max(rx, ry) / 3))
+		if n < 16 {
+			n = 16
+		} else if n > 720 {
+			n = 720
+		}
+		ring := make([][2]float64, n)
+		for i := range ring {
+			a := 2 * math.Pi * float64(i) / float64(n)
+			ring[i] = [2]float64{cx + rx*math.Cos(a), cy + ry*math.Sin(a)}
+		}
+		drawArea(img, [][][2]float64{ring}, c.Fill, c.Color, c.Width*float64(scale))
+	}
+}
+
+// circleRadius returns the radius of c in pixels across and down, using the scale of the map at its center.
+// They are the same in web mercator, which keeps the shape of small areas, but other grids may make the circle an ellipse.
+func circleRadius(c Circle, pixel func(lat, long float64) (float64, float64)) (float64, float64) {
+	// the radius in degrees of lat, and of long, which get closer towards the poles
+	dLat := c.Radius / earthRadius * 180 / math.Pi
+	dLong := dLat / math.Cos(c.Lat*math.Pi/180)
+
+	// the scale is measured over a small step, on the side that does not cross the antimeridian or the poles
+	const step = 1e-4
+	x, y := pixel(c.Lat, c.Long)
+	east, _ := pixel(c.Lat, c.Long+step)
+	west, _ := pixel(c.Lat, c.Long-step)
+	_, north := pixel(c.Lat+step, c.Long)
+	_, south := pixel(c.Lat-step, c.Long)
+
+	rx := math.Min(math.Abs(east-x), math.Abs(x-west)) / step * dLong
+	ry := math.Min(math.Abs(north-y), math.Abs(y-south)) / step * dLat
+	return rx, ry
+}
+
+// drawArea fills the rings, in pixels of img, with fill, and draws their outlines width pixels wide in c
+func drawArea(img *image.RGBA, rings [][][2]float64, fill, c color.NRGBA, width float64) {
+	fillRings(img, rings, fill)
+	if width <= 0 {
+		return
+	}
+	for _, ring := range rings {
+		stroke(img, append(ring[:len(ring):len(ring)], ring[0]), width, c)
+	}
+}
@@ -0,0 +1,185 @@
+package stitch
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParsePolygons(t *testing.T) {
+	square := [][2]float64{{1, 1}, {1, 4}, {4, 4}, {4, 1}}
+	hole := [][2]float64{{2, 2}, {2, 3}, {3, 3}}
+	google := [][2]float64{{38.5, -120.2}, {40.7, -120.95}, {43.252, -126.453}}
+
+	var polygonTests = []struct {
+		name   string
+		groups []string
+		want   []Polygon
+		err    bool
+	}{
+		{"default", []string{"1,1|1,4|4,4|4,1"}, []Polygon{{[][][2]float64{square}, defaultAreaFill, defaultPathColor, 2}}, false},
+		{"styles", []string{"color:red|width:0|opacity:0.5|fillcolor:0x00ff00|fillopacity:0.25|1,1|1,4|4,4|4,1"}, []Polygon{
+			{[][][2]float64{square}, color.NRGBA{0, 255, 0, 64}, color.NRGBA{255, 0, 0, 128}, 0},
+		}, false},
+		{"hole", []string{"1,1|1,4|4,4|4,1|hole|2,2|2,3|3,3"}, []Polygon{{[][][2]float64{square, hole}, defaultAreaFill, defaultPathColor, 2}}, false},
+		{"encoded hole", []string{"1,1|1,4|4,4|4,1|hole|enc:_p~iF~ps|U_ulLnnqC_mqNvxq`@"}, []Polygon{{[][][2]float64{square, google}, defaultAreaFill, defaultPathColor, 2}}, false},
+		{"two polygons", []string{"1,1|1,4|4,4|4,1", "width:3|2,2|2,3|3,3"}, []Polygon{
+			{[][][2]float64{square}, defaultAreaFill, defaultPathColor, 2},
+			{[][][2]float64{hole}, defaultAreaFill, defaultPathColor, 3},
+		}, false},
+		{"too few points", []string{"1,1|1,4"}, nil, true},
+		{"too few points in hole", []string{"1,1|1,4|4,4|hole|2,2|2,3"}, nil, true},
+		{"bad point", []string{"1,1|1,4|4"}, nil, true},
+		{"bad width", []string{"width:-1|1,1|1,4|4,4"}, nil, true},
+		{"bad fillopacity", []string{"fillopacity:2|1,1|1,4|4,4"}, nil, true},
+		{"bad style", []string{"radius:5|1,1|1,4|4,4"}, nil, true},
+	}
+
+	for _, test := range polygonTests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParsePolygons(test.groups)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v - want error %t", err, test.err)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d polygons - want %d", len(got), len(test.want))
+			}
+			for i := range got {
+				if got[i].Fill != test.want[i].Fill || got[i].Color != test.want[i].Color || got[i].Width != test.want[i].Width || len(got[i].Rings) != len(test.want[i].Rings) {
+					t.Fatalf("got polygon %+v - want %+v", got[i], test.want[i])
+				}
+				for j, ring := range got[i].Rings {
+					if len(ring) != len(test.want[i].Rings[j]) {
+						t.Fatalf("got polygon %+v - want %+v", got[i], test.want[i])
+					}
+					for k := range ring {
+						if math.Abs(ring[k][0]-test.want[i].Rings[j][k][0]) > 1e-9 || math.Abs(ring[k][1]-test.want[i].Rings[j][k][1]) > 1e-9 {
+							t.Errorf("got polygon %+v - want %+v", got[i], test.want[i])
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParseCircles(t *testing.T) {
+	var circleTests = []struct {
+		name   string
+		groups []string
+		want   []Circle
+		err    bool
+	}{
+		{"default", []string{"radius:500|59.9,10.7"}, []Circle{{59.9, 10.7, 500, defaultAreaFill, defaultPathColor, 2}}, false},
+		{"styles", []string{"radius:1.5|fillcolor:red|width:0|59.9,10.7|60,11"}, []Circle{
+			{59.9, 10.7, 1.5, color.NRGBA{255, 0, 0, 255}, defaultPathColor, 0},
+			{60, 11, 1.5, color.NRGBA{255, 0, 0, 255}, defaultPathColor, 0},
+		}, false},
+		{"no radius", []string{"59.9,10.7"}, nil, true},
+		{"no location", []string{"radius:500"}, nil, true},
+		{"bad radius", []string{"radius:0|59.9,10.7"}, nil, true},
+		{"too big radius", []string{"radius:2e7|59.9,10.7"}, nil, true},
+		{"bad style", []string{"radius:5|size:5|59.9,10.7"}, nil, true},
+	}
+
+	for _, test := range circleTests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseCircles(test.groups)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v - want error %t", err, test.err)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d circles - want %d", len(got), len(test.want))
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("got circle %+v - want %+v", got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDrawAreas(t *testing.T) {
+	white := color.RGBA{255, 255, 255, 255}
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), image.NewUniform(white), image.Point{}, draw.Src)
+
+	// lat and long are pixels, the hole is wound the same way as the outline, and the outline goes so far outside the image
+	// that the rasterizer would take forever on it
+	polygons, err := ParsePolygons([]string{"fillcolor:black|color:red|width:2|10,10|10,90|90,90|90,10|hole|40,40|40,60|60,60|60,40"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	polygons[0].Rings[0][3] = [2]float64{1e9, -1e9}
+
+	start := time.Now()
+	drawAreas(img, polygons, nil, 1, func(lat, long float64) (float64, float64) { return lat, long })
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("took %s to draw - want it quick", took)
+	}
+
+	black := color.RGBA{0, 0, 0, 255}
+	red := color.RGBA{255, 0, 0, 255}
+	var drawTests = []struct {
+		p    image.Point
+		want color.RGBA
+	}{
+		{image.Point{30, 50}, black},
+		{image.Point{50, 50}, white},
+		{image.Point{5, 50}, white},
+		{image.Point{10, 50}, red},
+		{image.Point{40, 50}, red},
+		{image.Point{50, 90}, red},
+	}
+	for _, test := range drawTests {
+		if got := img.RGBAAt(test.p.X, test.p.Y); got != test.want {
+			t.Errorf("got %v at %v - want %v", got, test.p, test.want)
+		}
+	}
+}
+
+func TestCircleRadius(t *testing.T) {
+	// web mercator at zoom 10, where the world is 256 * 2^10 pixels across
+	world := 256 * math.Pow(2, 10)
+	pixel := func(lat, long float64) (float64, float64) {
+		phi := lat * math.Pi / 180
+		return (long + 180) / 360 * world, (1 - math.Log(math.Tan(phi)+1/math.Cos(phi))/math.Pi) / 2 * world
+	}
+
+	for _, lat := range []float64{0, 45, 60, -70} {
+		rx, ry := circleRadius(Circle{Lat: lat, Long: 10, Radius: 1000}, pixel)
+
+		// the meters per pixel of web mercator at lat
+		want := 1000 / (2 * math.Pi * earthRadius * math.Cos(lat*math.Pi/180) / world)
+		if math.Abs(rx-want) > want*1e-3 || math.Abs(ry-want) > want*1e-3 {
+			t.Errorf("got radius %g, %g at lat %g - want %g", rx, ry, lat, want)
+		}
+	}
+
+	// circles are drawn round, 30 pixels across here
+	white := color.RGBA{255, 255, 255, 255}
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), image.NewUniform(white), image.Point{}, draw.Src)
+
+	k := 30 / (1000.0 / earthRadius * 180 / math.Pi)
+	circles, err := ParseCircles([]string{"radius:1000|fillcolor:black|width:0|0,0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drawAreas(img, nil, circles, 1, func(lat, long float64) (float64, float64) { return 50 + k*long, 50 - k*lat })
+
+	for _, p := range []image.Point{{50, 50}, {78, 50}, {50, 21}, {70, 70}} {
+		if got := img.RGBAAt(p.X, p.Y); got == white {
+			t.Errorf("got white at %v - want the circle", p)
+		}
+	}
+	for _, p := range []image.Point{{82, 50}, {50, 18}, {73, 73}} {
+		if got := img.RGBAAt(p.X, p.Y); got != white {
+			t.Errorf("got %v at %v - want white", got, p)
+		}
+	}
+}
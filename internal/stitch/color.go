@@ -0,0 +1,41 @@
+package stitch
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/colornames"
+)
+
+// parseColor parses a color by name, like red or steelblue (the SVG color names), or as hex, like 0xff0000, ff0000 or #ff0000.
+// Hex colors can have alpha, e.g. 0xff000080 for half transparent red.
+func parseColor(s string) (color.NRGBA, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if c, ok := colornames.Map[s]; ok {
+		return color.NRGBA{c.R, c.G, c.B, c.A}, nil
+	}
+
+	hex := strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "#")
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 8 || err != nil {
+		return color.NRGBA{}, fmt.Errorf("'%s' is not a color, use a name like red, or hex like 0xff0000", s)
+	}
+	return color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+}
+
+// darker returns c with less light, e.g. for the outline of a marker
+func darker(c color.NRGBA) color.NRGBA {
+	d := func(v uint8) uint8 { return uint8(int(v) * 3 / 5) }
+	return color.NRGBA{d(c.R), d(c.G), d(c.B), c.A}
+}
+
+// isLight reports if c is so light that black text on it is easier to read than white
+func isLight(c color.NRGBA) bool {
+	// the relative luminance of sRGB, without the gamma
+	return 0.2126*float64(c.R)+0.7152*float64(c.G)+0.0722*float64(c.B) > 150
+}
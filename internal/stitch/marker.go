@@ -0,0 +1,385 @@
+package stitch
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/inconsolata"
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+// Marker is a point on the map, drawn as an icon
+type Marker struct {
+	Lat   float64
+	Long  float64
+	Color color.NRGBA
+	// Size is the height of the icon in pixels, at scale 1
+	Size int
+	// AnchorX and AnchorY is the point of the icon that is at Lat and Long, as fractions of its width and height,
+	// e.g. 0.5 and 1 for the middle of the bottom, which is the tip of a pin
+	AnchorX float64
+	AnchorY float64
+	// Label is a short text on the icon, e.g. a letter or a number
+	Label string
+	// Icon is the name of the icon, either one of the built in pin, circle and square, or one of the custom icons, see LoadIcons
+	Icon string
+
+	custom image.Image
+}
+
+// Built in icons, which are drawn in the color of the marker
+const (
+	pinIcon    = "pin"
+	circleIcon = "circle"
+	squareIcon = "square"
+)
+
+// Icons are custom icons for markers, by name
+type Icons map[string]image.Image
+
+// markerSizes are the named sizes of markers, in pixels at scale 1
+var markerSizes = map[string]int{
+	"tiny":  12,
+	"small": 16,
+	"mid":   24,
+	"large": 32,
+}
+
+// Limits of markers
+const (
+	minMarkerSize  = 8
+	maxMarkerSize  = 96
+	maxMarkers     = 256
+	maxMarkerLabel = 3
+)
+
+// anchors are the named anchors of markers, as fractions of the width and height of the icon
+var anchors = map[string][2]float64{
+	"center":      {0.5, 0.5},
+	"top":         {0.5, 0},
+	"bottom":      {0.5, 1},
+	"left":        {0, 0.5},
+	"right":       {1, 0.5},
+	"topleft":     {0, 0},
+	"topright":    {1, 0},
+	"bottomleft":  {0, 1},
+	"bottomright": {1, 1},
+}
+
+// defaultMarkerColor is the color of markers that do not have one
+var defaultMarkerColor = color.NRGBA{0xd3, 0x2f, 0x2f, 0xff}
+
+// LoadIcons loads the png files in dir as custom icons for markers, named by the file name without .png, e.g. hospital for hospital.png.
+// Custom icons are drawn as they are, and not in the color of the marker.
+func LoadIcons(dir string) (Icons, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.png"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not list icons in %s", dir)
+	}
+
+	icons := Icons{}
+	for _, path := range paths {
+		name := strings.ToLower(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+		switch name {
+		case pinIcon, circleIcon, squareIcon:
+			return nil, fmt.Errorf("icon %s has the name of a built in icon", path)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not open icon %s", path)
+		}
+		icon, err := png.Decode(f)
+		f.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not decode icon %s", path)
+		}
+		if icon.Bounds().Empty() {
+			return nil, fmt.Errorf("icon %s is empty", path)
+		}
+		icons[name] = icon
+	}
+
+	return icons, nil
+}
+
+// ParseMarkers parses groups of markers, like color:blue|size:large|label:A|59.92,10.77|59.93,10.78, with a marker at each lat,long of a group.
+// The styles of a group apply to all of its markers:
+//
+//	color   a name like red, or hex like 0xff0000, see parseColor
+//	size    tiny, small, mid (the default), large, or the height in pixels
+//	anchor  the point of the icon at lat,long: center, top, bottom, left, right, topleft, topright, bottomleft, bottomright, or x,y in pixels of the icon
+//	label   up to 3 letters or digits on the icon
+//	icon    pin (the default), circle, square, or the name of one of icons
+func ParseMarkers(groups []string, icons Icons) ([]Marker, error) {
+	var markers []Marker
+
+	for _, group := range groups {
+		m := Marker{Color: defaultMarkerColor, Size: markerSizes["mid"], Icon: pinIcon}
+		anchor := ""
+		var locations [][2]float64
+
+		for _, item := range strings.Split(group, "|") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+
+			i := strings.Index(item, ":")
+			if i < 0 {
+				lat, long, err := parseLocation(item)
+				if err != nil {
+					return nil, err
+				}
+				locations = append(locations, [2]float64{lat, long})
+				continue
+			}
+
+			var err error
+			key, value := strings.ToLower(item[:i]), strings.TrimSpace(item[i+1:])
+			switch key {
+			case "color":
+				m.Color, err = parseColor(value)
+			case "size":
+				m.Size, err = parseMarkerSize(value)
+			case "anchor":
+				anchor = value
+			case "label":
+				m.Label, err = parseMarkerLabel(value)
+			case "icon":
+				m.Icon, m.custom = strings.ToLower(value), nil
+				switch m.Icon {
+				case pinIcon, circleIcon, squareIcon:
+				default:
+					var ok bool
+					if m.custom, ok = icons[m.Icon]; !ok {
+						err = fmt.Errorf("unknown icon '%s'", value)
+					}
+				}
+			default:
+				err = fmt.Errorf("unknown marker style '%s', use color, size, anchor, label or icon", key)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(locations) == 0 {
+			return nil, fmt.Errorf("markers '%s' have no lat,long", group)
+		}
+
+		var err error
+		m.AnchorX, m.AnchorY, err = m.parseAnchor(anchor)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, l := range locations {
+			m.Lat, m.Long = l[0], l[1]
+			markers = append(markers, m)
+		}
+	}
+
+	if len(markers) > maxMarkers {
+		return nil, fmt.Errorf("there are %d markers, which is more than the %d allowed", len(markers), maxMarkers)
+	}
+
+	return markers, nil
+}
+
+// parseLocation parses a lat,long pair
+func parseLocation(s string) (float64, float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) == 2 {
+		lat, errLat := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		long, errLong := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if errLat == nil && errLong == nil && math.Abs(lat) <= 90 && math.Abs(long) <= 180 {
+			return lat, long, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("'%s' is not a lat,long", s)
+}
+
+// parseMarkerSize parses a named size or a size in pixels
+func parseMarkerSize(s string) (int, error) {
+	if size, ok := markerSizes[strings.ToLower(s)]; ok {
+		return size, nil
+	}
+	size, err := strconv.Atoi(s)
+	if err != nil || size < minMarkerSize || size > maxMarkerSize {
+		return 0, fmt.Errorf("marker size '%s' is not tiny, small, mid, large or pixels from %d to %d", s, minMarkerSize, maxMarkerSize)
+	}
+	return size, nil
+}
+
+// parseMarkerLabel checks that s is a label that fits on a marker
+func parseMarkerLabel(s string) (string, error) {
+	if len(s) > maxMarkerLabel {
+		return "", fmt.Errorf("marker label '%s' is longer than %d letters", s, maxMarkerLabel)
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return "", fmt.Errorf("marker label '%s' is not letters or digits", s)
+		}
+	}
+	return s, nil
+}
+
+// parseAnchor parses the anchor of m, which is a name or x,y in pixels of the icon, see ParseMarkers.
+// Pins and custom icons are anchored at the middle of the bottom by default, other icons at the center.
+func (m Marker) parseAnchor(s string) (float64, float64, error) {
+	if s == "" {
+		if m.Icon == circleIcon || m.Icon == squareIcon {
+			return 0.5, 0.5, nil
+		}
+		return 0.5, 1, nil
+	}
+
+	if a, ok := anchors[strings.ToLower(s)]; ok {
+		return a[0], a[1], nil
+	}
+
+	parts := strings.Split(s, ",")
+	if len(parts) == 2 {
+		x, errX := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		y, errY := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		w, h := m.size()
+		if errX == nil && errY == nil && x >= 0 && x <= w && y >= 0 && y <= h {
+			return x / w, y / h, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("marker anchor '%s' is not a name like bottom or x,y in the icon", s)
+}
+
+// size returns the width and height of the icon of m, in pixels at scale 1
+func (m Marker) size() (float64, float64) {
+	h := float64(m.Size)
+	switch {
+	case m.custom != nil:
+		b := m.custom.Bounds()
+		return h * float64(b.Dx()) / float64(b.Dy()), h
+	case m.Icon == pinIcon:
+		return h * 0.7, h
+	}
+	return h, h
+}
+
+// drawMarkers draws markers on img, a map with pixel density scale, where pixel converts lat and long to pixels of img
+func drawMarkers(img *image.RGBA, markers []Marker, scale int, pixel func(lat, long float64) (float64, float64)) {
+	for _, m := range markers {
+		x, y := pixel(m.Lat, m.Long)
+		m.draw(img, x, y, float64(scale))
+	}
+}
+
+// draw draws m with its anchor at x, y of dst, scaled by scale
+func (m Marker) draw(dst *image.RGBA, x, y, scale float64) {
+	w, h := m.size()
+	w, h = w*scale, h*scale
+	left, top := x-m.AnchorX*w, y-m.AnchorY*h
+
+	if m.custom != nil {
+		b := m.custom.Bounds()
+		draw.CatmullRom.Transform(dst, f64.Aff3{w / float64(b.Dx()), 0, left, 0, h / float64(b.Dy()), top}, m.custom, b, draw.Over, nil)
+		drawText(dst, m.Label, left+w/2, top+h/2, h*0.4, w*0.8, color.Black)
+		return
+	}
+
+	// the icon is drawn in whole pixels, with the shapes offset by the fractions, so that it is exactly at x, y
+	r := image.Rect(int(math.Floor(left)), int(math.Floor(top)), int(math.Ceil(left+w)), int(math.Ceil(top+h)))
+	dx, dy := left-float64(r.Min.X), top-float64(r.Min.Y)
+
+	// the icon is filled with a darker outline, and the color inside it
+	outline := math.Max(1, float64(m.Size)/16) * scale
+	text := color.Color(color.White)
+	if isLight(m.Color) {
+		text = color.Black
+	}
+
+	switch m.Icon {
+	case pinIcon:
+		// a circle with the tip below it, where the sides are tangents to the circle
+		radius := w / 2
+		cx, cy, tip := dx+radius, dy+radius, dy+h
+		fill(dst, r, darker(m.Color), func(z *vector.Rasterizer) { pin(z, cx, cy, radius, tip) })
+		// the sides are moved in by the outline, which moves the tip up by more, as the sides are slanted
+		fill(dst, r, m.Color, func(z *vector.Rasterizer) { pin(z, cx, cy, radius-outline, tip-outline*(tip-cy)/radius) })
+		if m.Label == "" {
+			fill(dst, r, darker(m.Color), func(z *vector.Rasterizer) { circle(z, cx, cy, radius*0.35) })
+		}
+		drawText(dst, m.Label, left+radius, top+radius, radius*0.9, radius*1.5, text)
+	case circleIcon:
+		radius := w / 2
+		cx, cy := dx+radius, dy+radius
+		fill(dst, r, darker(m.Color), func(z *vector.Rasterizer) { circle(z, cx, cy, radius) })
+		fill(dst, r, m.Color, func(z *vector.Rasterizer) { circle(z, cx, cy, radius-outline) })
+		drawText(dst, m.Label, left+radius, top+radius, radius*0.9, radius*1.5, text)
+	case squareIcon:
+		fill(dst, r, darker(m.Color), func(z *vector.Rasterizer) { rect(z, dx, dy, dx+w, dy+h) })
+		fill(dst, r, m.Color, func(z *vector.Rasterizer) { rect(z, dx+outline, dy+outline, dx+w-outline, dy+h-outline) })
+		drawText(dst, m.Label, left+w/2, top+h/2, h*0.45, w*0.8, text)
+	}
+}
+
+// pin adds the shape of a pin to z, which is a circle around cx, cy with radius r, and a tip straight below it at tip
+func pin(z *vector.Rasterizer, cx, cy, r, tip float64) {
+	// the sides touch the circle where they are at right angles to the radius
+	a := math.Acos(r / (tip - cy))
+	z.MoveTo(float32(cx), float32(tip))
+	z.LineTo(float32(cx+r*math.Cos(math.Pi/2-a)), float32(cy+r*math.Sin(math.Pi/2-a)))
+	// over the top of the circle, from the right side to the left
+	arc(z, cx, cy, r, math.Pi/2-a, math.Pi/2+a-2*math.Pi)
+	z.ClosePath()
+}
+
+// rect adds a rectangle to z
+func rect(z *vector.Rasterizer, minX, minY, maxX, maxY float64) {
+	z.MoveTo(float32(minX), float32(minY))
+	z.LineTo(float32(maxX), float32(minY))
+	z.LineTo(float32(maxX), float32(maxY))
+	z.LineTo(float32(minX), float32(maxY))
+	z.ClosePath()
+}
+
+// drawText draws text centered at x, y of dst, in c, with capital letters px high, but no wider than maxWidth
+func drawText(dst *image.RGBA, text string, x, y, px, maxWidth float64, c color.Color) {
+	if text == "" {
+		return
+	}
+
+	face := inconsolata.Bold8x16
+	bounds, _ := font.BoundString(face, text)
+	capital, _ := font.BoundString(face, "H")
+
+	// the text is drawn in the size of the font, right at the edges of the letters, and scaled to px
+	w, h := (bounds.Max.X - bounds.Min.X).Ceil(), (bounds.Max.Y - bounds.Min.Y).Ceil()
+	if w <= 0 || h <= 0 {
+		return
+	}
+	txt := image.NewRGBA(image.Rect(0, 0, w, h))
+	d := &font.Drawer{
+		Dst:  txt,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.Point26_6{X: -bounds.Min.X, Y: -bounds.Min.Y},
+	}
+	d.DrawString(text)
+
+	k := px / float64((capital.Max.Y - capital.Min.Y).Ceil())
+	if float64(w)*k > maxWidth {
+		k = maxWidth / float64(w)
+	}
+	draw.CatmullRom.Transform(dst, f64.Aff3{k, 0, x - float64(w)*k/2, 0, k, y - float64(h)*k/2}, txt, txt.Bounds(), draw.Over, nil)
+}
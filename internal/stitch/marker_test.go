@@ -0,0 +1,136 @@
+package stitch
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestParseColor(t *testing.T) {
+	var colorTests = []struct {
+		s    string
+		want color.NRGBA
+		err  bool
+	}{
+		{"red", color.NRGBA{255, 0, 0, 255}, false},
+		{"SteelBlue", color.NRGBA{70, 130, 180, 255}, false},
+		{"0x00ff00", color.NRGBA{0, 255, 0, 255}, false},
+		{"#0000ff80", color.NRGBA{0, 0, 255, 128}, false},
+		{"123456", color.NRGBA{0x12, 0x34, 0x56, 255}, false},
+		{"rainbow", color.NRGBA{}, true},
+		{"0xfff", color.NRGBA{}, true},
+		{"0xgggggg", color.NRGBA{}, true},
+	}
+
+	for _, test := range colorTests {
+		t.Run(test.s, func(t *testing.T) {
+			got, err := parseColor(test.s)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v - want error %t", err, test.err)
+			}
+			if got != test.want {
+				t.Errorf("got %v - want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseMarkers(t *testing.T) {
+	icons := Icons{"hospital": image.NewRGBA(image.Rect(0, 0, 20, 10))}
+
+	var markerTests = []struct {
+		name   string
+		groups []string
+		want   []Marker
+		err    bool
+	}{
+		{"default", []string{"59.9,10.7"}, []Marker{{Lat: 59.9, Long: 10.7, Color: defaultMarkerColor, Size: 24, AnchorX: 0.5, AnchorY: 1, Icon: "pin"}}, false},
+		{"styles", []string{"color:blue|size:large|label:A|59.9,10.7|60,11"}, []Marker{
+			{Lat: 59.9, Long: 10.7, Color: color.NRGBA{0, 0, 255, 255}, Size: 32, AnchorX: 0.5, AnchorY: 1, Label: "A", Icon: "pin"},
+			{Lat: 60, Long: 11, Color: color.NRGBA{0, 0, 255, 255}, Size: 32, AnchorX: 0.5, AnchorY: 1, Label: "A", Icon: "pin"},
+		}, false},
+		{"groups", []string{"label:1|1,2", "icon:circle|size:20|3,4"}, []Marker{
+			{Lat: 1, Long: 2, Color: defaultMarkerColor, Size: 24, AnchorX: 0.5, AnchorY: 1, Label: "1", Icon: "pin"},
+			{Lat: 3, Long: 4, Color: defaultMarkerColor, Size: 20, AnchorX: 0.5, AnchorY: 0.5, Icon: "circle"},
+		}, false},
+		{"anchor", []string{"icon:square|anchor:topleft|1,2"}, []Marker{{Lat: 1, Long: 2, Color: defaultMarkerColor, Size: 24, AnchorX: 0, AnchorY: 0, Icon: "square"}}, false},
+		{"pixel anchor", []string{"icon:hospital|size:20|anchor:10,5|1,2"}, []Marker{{Lat: 1, Long: 2, Color: defaultMarkerColor, Size: 20, AnchorX: 0.25, AnchorY: 0.25, Icon: "hospital", custom: icons["hospital"]}}, false},
+		{"no location", []string{"color:red"}, nil, true},
+		{"bad location", []string{"91,10"}, nil, true},
+		{"bad style", []string{"shape:star|1,2"}, nil, true},
+		{"bad color", []string{"color:rainbow|1,2"}, nil, true},
+		{"bad size", []string{"size:200|1,2"}, nil, true},
+		{"bad anchor", []string{"anchor:50,50|1,2"}, nil, true},
+		{"bad label", []string{"label:ABCD|1,2"}, nil, true},
+		{"bad label letters", []string{"label:A B|1,2"}, nil, true},
+		{"unknown icon", []string{"icon:church|1,2"}, nil, true},
+	}
+
+	for _, test := range markerTests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseMarkers(test.groups, icons)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v - want error %t", err, test.err)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d markers - want %d", len(got), len(test.want))
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("got marker %+v - want %+v", got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDrawMarkers(t *testing.T) {
+	white := color.RGBA{255, 255, 255, 255}
+
+	var drawTests = []struct {
+		name   string
+		marker string
+		scale  int
+		// inside and outside are pixels relative to the lat,long of the marker
+		inside, outside []image.Point
+	}{
+		{"pin", "1,1", 1, []image.Point{{0, -2}, {0, -20}}, []image.Point{{0, 2}, {-10, -10}, {0, -26}}},
+		{"pin scale 2", "1,1", 2, []image.Point{{0, -4}, {0, -40}}, []image.Point{{0, 4}, {-20, -20}, {0, -50}}},
+		{"circle", "icon:circle|1,1", 1, []image.Point{{0, 0}, {10, 0}, {0, -10}}, []image.Point{{13, 0}, {0, 13}}},
+		{"square bottomright", "icon:square|anchor:bottomright|1,1", 1, []image.Point{{-2, -2}, {-22, -22}}, []image.Point{{2, 2}, {-26, -12}}},
+	}
+
+	for _, test := range drawTests {
+		t.Run(test.name, func(t *testing.T) {
+			markers, err := ParseMarkers([]string{test.marker}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+			draw.Draw(img, img.Bounds(), image.NewUniform(white), image.Point{}, draw.Src)
+
+			// the marker is at 50, 70 of the map
+			drawMarkers(img, markers, test.scale, func(lat, long float64) (float64, float64) { return 50 * lat, 70 * long })
+
+			for _, p := range test.inside {
+				if got := img.RGBAAt(50+p.X, 70+p.Y); got == white {
+					t.Errorf("got white at %v - want the marker", p)
+				}
+			}
+			for _, p := range test.outside {
+				if got := img.RGBAAt(50+p.X, 70+p.Y); got != white {
+					t.Errorf("got %v at %v - want white", got, p)
+				}
+			}
+		})
+	}
+
+	// markers off the map are left out, and markers partly on it are cut off
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	markers, _ := ParseMarkers([]string{"icon:circle|size:large|1,1"}, nil)
+	for _, p := range [][2]float64{{-100, -100}, {0, 0}, {10, 10}, {1000, 5}} {
+		drawMarkers(img, markers, 1, func(lat, long float64) (float64, float64) { return p[0], p[1] })
+	}
+}
@@ -0,0 +1,189 @@
+package stitch
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Path is a line through points on the map, e.g. a route
+type Path struct {
+	// Points are lat and long
+	Points [][2]float64
+	// Color is the color of the line, with the opacity as alpha
+	Color color.NRGBA
+	// Width is the width of the line in pixels, at scale 1
+	Width float64
+}
+
+// Limits of paths
+const (
+	maxPathWidth  = 50
+	maxPathPoints = 10000
+)
+
+// defaultPathColor is the color of paths that do not have one
+var defaultPathColor = color.NRGBA{0x1e, 0x64, 0xd2, 0xff}
+
+// defaultPathWidth is the width of paths that do not have one
+const defaultPathWidth = 4
+
+// ParsePaths parses paths, like color:blue|width:5|opacity:0.8|59.92,10.77|59.93,10.78|59.95,10.76, through each lat,long of a path in order.
+// The points can also be a Google encoded polyline, like enc:_p~iF~ps|U_ulLnnqC_mqNvxq`@, which must be last, as it can have | in it.
+// The styles of a path are:
+//
+//	color    a name like red, or hex like 0xff0000, see parseColor
+//	width    the width of the line in pixels, 4 by default
+//	opacity  from 0 to 1, which is multiplied with the alpha of the color
+func ParsePaths(groups []string) ([]Path, error) {
+	var paths []Path
+	total := 0
+
+	for _, group := range groups {
+		p := Path{Color: defaultPathColor, Width: defaultPathWidth}
+		opacity := 1.0
+
+		items := strings.Split(group, "|")
+		for i := 0; i < len(items); i++ {
+			item := strings.TrimSpace(items[i])
+			if item == "" {
+				continue
+			}
+
+			if strings.HasPrefix(item, "enc:") {
+				// encoded polylines can have | in them, so the rest of the group is the polyline
+				enc := strings.TrimPrefix(strings.TrimSpace(strings.Join(items[i:], "|")), "enc:")
+				points, err := decodePolyline(enc)
+				if err != nil {
+					return nil, err
+				}
+				p.Points = append(p.Points, points...)
+				break
+			}
+
+			j := strings.Index(item, ":")
+			if j < 0 {
+				lat, long, err := parseLocation(item)
+				if err != nil {
+					return nil, err
+				}
+				p.Points = append(p.Points, [2]float64{lat, long})
+				continue
+			}
+
+			var err error
+			key, value := strings.ToLower(item[:j]), strings.TrimSpace(item[j+1:])
+			switch key {
+			case "color":
+				p.Color, err = parseColor(value)
+			case "width":
+				p.Width, err = parseWidth(value, false)
+			case "opacity":
+				opacity, err = parseOpacity(value)
+			default:
+				err = fmt.Errorf("unknown path style '%s', use color, width or opacity", key)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(p.Points) == 0 {
+			return nil, fmt.Errorf("path '%s' has no lat,long", group)
+		}
+
+		p.Color = withOpacity(p.Color, opacity)
+		paths = append(paths, p)
+
+		total += len(p.Points)
+		if total > maxPathPoints {
+			return nil, fmt.Errorf("paths have more than the %d points allowed", maxPathPoints)
+		}
+	}
+
+	return paths, nil
+}
+
+// parseWidth parses the width of a line in pixels, up to maxPathWidth. If none is set, the width can be 0, for no line.
+func parseWidth(s string, none bool) (float64, error) {
+	width, err := strconv.ParseFloat(s, 64)
+	if err != nil || !(width > 0 || none && width == 0) || width > maxPathWidth {
+		if none {
+			return 0, fmt.Errorf("width '%s' is not a number of pixels from 0 to %d", s, maxPathWidth)
+		}
+		return 0, fmt.Errorf("width '%s' is not a number of pixels above 0 and up to %d", s, maxPathWidth)
+	}
+	return width, nil
+}
+
+// parseOpacity parses an opacity from 0 to 1
+func parseOpacity(s string) (float64, error) {
+	opacity, err := strconv.ParseFloat(s, 64)
+	if err != nil || !(opacity >= 0 && opacity <= 1) {
+		return 0, fmt.Errorf("opacity '%s' is not from 0 to 1", s)
+	}
+	return opacity, nil
+}
+
+// withOpacity returns c with its alpha multiplied by opacity
+func withOpacity(c color.NRGBA, opacity float64) color.NRGBA {
+	c.A = uint8(math.Round(float64(c.A) * opacity))
+	return c
+}
+
+// decodePolyline decodes a Google encoded polyline to lat and long, see
+// https://developers.google.com/maps/documentation/utilities/polylinealgorithm
+func decodePolyline(s string) ([][2]float64, error) {
+	var values []int
+	value, shift := 0, uint(0)
+	for i := 0; i < len(s); i++ {
+		// each character has 5 bits of the value, and a bit that tells if there are more
+		b := int(s[i]) - 63
+		if b < 0 || b > 63 {
+			return nil, fmt.Errorf("encoded polyline has an invalid character '%c' at %d", s[i], i)
+		}
+		if shift > 30 {
+			return nil, fmt.Errorf("encoded polyline has a value that is too big at %d", i)
+		}
+		value |= (b & 0x1f) << shift
+		shift += 5
+		if b < 0x20 {
+			// the values are the differences from the one before, with the sign in the lowest bit
+			if value&1 != 0 {
+				value = ^value
+			}
+			values = append(values, value>>1)
+			value, shift = 0, 0
+		}
+	}
+	if shift != 0 || len(values)%2 != 0 {
+		return nil, fmt.Errorf("encoded polyline ends in the middle of a point")
+	}
+
+	points := make([][2]float64, 0, len(values)/2)
+	lat, long := 0, 0
+	for i := 0; i < len(values); i += 2 {
+		lat += values[i]
+		long += values[i+1]
+		point := [2]float64{float64(lat) / 1e5, float64(long) / 1e5}
+		if math.Abs(point[0]) > 90 || math.Abs(point[1]) > 180 {
+			return nil, fmt.Errorf("encoded polyline has a point at %g,%g, which is not a lat,long", point[0], point[1])
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
+
+// drawPaths draws paths on img, a map with pixel density scale, where pixel converts lat and long to pixels of img
+func drawPaths(img *image.RGBA, paths []Path, scale int, pixel func(lat, long float64) (float64, float64)) {
+	for _, p := range paths {
+		points := make([][2]float64, len(p.Points))
+		for i, point := range p.Points {
+			points[i][0], points[i][1] = pixel(point[0], point[1])
+		}
+		stroke(img, points, p.Width*float64(scale), p.Color)
+	}
+}
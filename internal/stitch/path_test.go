@@ -0,0 +1,147 @@
+package stitch
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDecodePolyline(t *testing.T) {
+	var polylineTests = []struct {
+		enc  string
+		want [][2]float64
+		err  bool
+	}{
+		// the example of the documentation of the algorithm
+		{"_p~iF~ps|U_ulLnnqC_mqNvxq`@", [][2]float64{{38.5, -120.2}, {40.7, -120.95}, {43.252, -126.453}}, false},
+		{"", [][2]float64{}, false},
+		{"_p~iF", nil, true},
+		{"_p~iF~ps|", nil, true},
+		{"_p~iF ~ps|U", nil, true},
+		{"~~~~~~~~~~~~~~?", nil, true},
+	}
+
+	for _, test := range polylineTests {
+		t.Run(test.enc, func(t *testing.T) {
+			got, err := decodePolyline(test.enc)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v - want error %t", err, test.err)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %v - want %v", got, test.want)
+			}
+			for i := range got {
+				if math.Abs(got[i][0]-test.want[i][0]) > 1e-9 || math.Abs(got[i][1]-test.want[i][1]) > 1e-9 {
+					t.Errorf("got point %v - want %v", got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParsePaths(t *testing.T) {
+	google := [][2]float64{{38.5, -120.2}, {40.7, -120.95}, {43.252, -126.453}}
+
+	var pathTests = []struct {
+		name   string
+		groups []string
+		want   []Path
+		err    bool
+	}{
+		{"default", []string{"59.9,10.7|60,11"}, []Path{{[][2]float64{{59.9, 10.7}, {60, 11}}, defaultPathColor, 4}}, false},
+		{"styles", []string{"color:red|width:2.5|opacity:0.5|59.9,10.7"}, []Path{{[][2]float64{{59.9, 10.7}}, color.NRGBA{255, 0, 0, 128}, 2.5}}, false},
+		{"encoded", []string{"width:6|enc:_p~iF~ps|U_ulLnnqC_mqNvxq`@"}, []Path{{google, defaultPathColor, 6}}, false},
+		{"points and encoded", []string{"38,-120|enc:_p~iF~ps|U_ulLnnqC_mqNvxq`@"}, []Path{{append([][2]float64{{38, -120}}, google...), defaultPathColor, 4}}, false},
+		{"two paths", []string{"1,2|3,4", "color:0x00ff0080|5,6"}, []Path{
+			{[][2]float64{{1, 2}, {3, 4}}, defaultPathColor, 4},
+			{[][2]float64{{5, 6}}, color.NRGBA{0, 255, 0, 128}, 4},
+		}, false},
+		{"no points", []string{"color:red"}, nil, true},
+		{"bad point", []string{"1,2|3"}, nil, true},
+		{"bad width", []string{"width:0|1,2"}, nil, true},
+		{"bad opacity", []string{"opacity:2|1,2"}, nil, true},
+		{"bad style", []string{"weight:5|1,2"}, nil, true},
+		{"bad encoded", []string{"enc:_p~iF"}, nil, true},
+	}
+
+	for _, test := range pathTests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParsePaths(test.groups)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v - want error %t", err, test.err)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d paths - want %d", len(got), len(test.want))
+			}
+			for i := range got {
+				if got[i].Color != test.want[i].Color || got[i].Width != test.want[i].Width || len(got[i].Points) != len(test.want[i].Points) {
+					t.Fatalf("got path %+v - want %+v", got[i], test.want[i])
+				}
+				for j := range got[i].Points {
+					if math.Abs(got[i].Points[j][0]-test.want[i].Points[j][0]) > 1e-9 || math.Abs(got[i].Points[j][1]-test.want[i].Points[j][1]) > 1e-9 {
+						t.Errorf("got path %+v - want %+v", got[i], test.want[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestStroke(t *testing.T) {
+	white := color.RGBA{255, 255, 255, 255}
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), image.NewUniform(white), image.Point{}, draw.Src)
+
+	// an X with a sharp turn, in half transparent black, and a last point so far away that the rasterizer would take forever on it
+	start := time.Now()
+	stroke(img, [][2]float64{{10, 10}, {90, 90}, {90, 10}, {10, 90}, {-1e9, 1e9}}, 6, color.NRGBA{0, 0, 0, 128})
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("took %s to stroke - want it quick", took)
+	}
+
+	half := img.RGBAAt(30, 30)
+	if half.R < 120 || half.R > 135 {
+		t.Fatalf("got %v on the line - want half black", half)
+	}
+
+	// the line is as dark where it crosses itself and turns as elsewhere
+	for _, p := range []image.Point{{50, 50}, {90, 90}, {90, 10}, {10, 10}} {
+		if got := img.RGBAAt(p.X, p.Y); got != half {
+			t.Errorf("got %v at %v - want %v", got, p, half)
+		}
+	}
+
+	// the line is 6 pixels wide, with round ends
+	for _, p := range []image.Point{{50, 40}, {50, 10}, {7, 7}, {95, 95}} {
+		if got := img.RGBAAt(p.X, p.Y); got != white {
+			t.Errorf("got %v at %v - want white", got, p)
+		}
+	}
+	if got := img.RGBAAt(32, 30); got != half {
+		t.Errorf("got %v beside the middle of the line - want %v", got, half)
+	}
+}
+
+func TestRequestHash(t *testing.T) {
+	path := func(points ...[2]float64) []Path { return []Path{{points, defaultPathColor, 4}} }
+	a := Request{Width: 100, Height: 100, Paths: path([2]float64{1, 2}, [2]float64{3, 4})}
+	b := Request{Width: 100, Height: 100, Paths: path([2]float64{1, 2}, [2]float64{3, 5})}
+	c := Request{Width: 100, Height: 100}
+
+	if a.hash() == b.hash() || a.hash() == c.hash() {
+		t.Errorf("got the same hash for requests with different paths")
+	}
+
+	d := Request{Width: 100, Height: 100, Circles: []Circle{{Lat: 1, Long: 2, Radius: 500}}}
+	e := Request{Width: 100, Height: 100, Circles: []Circle{{Lat: 1, Long: 2, Radius: 600}}}
+	f := Request{Width: 100, Height: 100, Polygons: []Polygon{{Rings: [][][2]float64{{{1, 2}, {3, 4}, {5, 6}}}}}}
+	if d.hash() == e.hash() || d.hash() == c.hash() || f.hash() == c.hash() {
+		t.Errorf("got the same hash for requests with different areas")
+	}
+	if a.hash() != (Request{Width: 100, Height: 100, Paths: path([2]float64{1, 2}, [2]float64{3, 4})}).hash() {
+		t.Errorf("got different hashes for the same request")
+	}
+}
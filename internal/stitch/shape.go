@@ -0,0 +1,228 @@
+package stitch
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/vector"
+)
+
+// fill fills the shape that path adds to a rasterizer of the size of r with c, anti-aliased, and draws it over r of dst.
+// The coordinates of path are relative to the top left corner of r, and r may be partly or wholly outside dst.
+func fill(dst *image.RGBA, r image.Rectangle, c color.Color, path func(z *vector.Rasterizer)) {
+	if r.Empty() || !r.Overlaps(dst.Bounds()) {
+		return
+	}
+
+	z := vector.NewRasterizer(r.Dx(), r.Dy())
+	path(z)
+
+	// the rasterizer does not clip, so the shape is drawn as a mask, which draw.DrawMask clips to dst
+	mask := image.NewAlpha(image.Rect(0, 0, r.Dx(), r.Dy()))
+	z.Draw(mask, mask.Bounds(), image.Opaque, image.Point{})
+	draw.DrawMask(dst, r, image.NewUniform(c), image.Point{}, mask, image.Point{}, draw.Over)
+}
+
+// arc adds an arc of the circle around cx, cy with radius r, from angle a0 to a1 in radians, to z, which must be at the start of the arc.
+// Angles go clockwise from east, as y goes down in images. The arc is made of cubic Bézier curves of at most a quarter circle each.
+func arc(z *vector.Rasterizer, cx, cy, r, a0, a1 float64) {
+	n := int(math.Ceil(math.Abs(a1-a0) / (math.Pi / 2)))
+	if n == 0 {
+		return
+	}
+	step := (a1 - a0) / float64(n)
+	// the control points are on the tangents, at the distance that makes the curve closest to the circle
+	k := 4.0 / 3 * math.Tan(step/4) * r
+
+	for i := 0; i < n; i++ {
+		t0, t1 := a0+float64(i)*step, a0+float64(i+1)*step
+		x0, y0 := cx+r*math.Cos(t0), cy+r*math.Sin(t0)
+		x1, y1 := cx+r*math.Cos(t1), cy+r*math.Sin(t1)
+		z.CubeTo(
+			float32(x0-k*math.Sin(t0)), float32(y0+k*math.Cos(t0)),
+			float32(x1+k*math.Sin(t1)), float32(y1-k*math.Cos(t1)),
+			float32(x1), float32(y1),
+		)
+	}
+}
+
+// circle adds a circle around cx, cy with radius r to z
+func circle(z *vector.Rasterizer, cx, cy, r float64) {
+	z.MoveTo(float32(cx+r), float32(cy))
+	arc(z, cx, cy, r, 0, 2*math.Pi)
+	z.ClosePath()
+}
+
+// stroke draws a line through points of dst, width pixels wide with round joins and ends, anti-aliased, in c.
+// The line is filled in one go, so that it is not darker where it crosses itself if c is translucent.
+func stroke(dst *image.RGBA, points [][2]float64, width float64, c color.Color) {
+	if len(points) == 0 {
+		return
+	}
+
+	// Segments are clipped to a little outside dst, as the rasterizer goes through every row above the points,
+	// which takes forever for points far outside the map. The ends of the clipped segments are outside dst, so they do not show.
+	b := dst.Bounds()
+	r := width / 2
+	clip := [4]float64{float64(b.Min.X) - r - 1, float64(b.Min.Y) - r - 1, float64(b.Max.X) + r + 1, float64(b.Max.Y) + r + 1}
+
+	// the rasterizer only needs to cover the line, which is usually much smaller than dst
+	area := image.Rectangle{}
+	for _, p := range points {
+		x, y := math.Max(clip[0], math.Min(clip[2], p[0])), math.Max(clip[1], math.Min(clip[3], p[1]))
+		area = area.Union(image.Rect(int(math.Floor(x-r-1)), int(math.Floor(y-r-1)), int(math.Ceil(x+r+1)), int(math.Ceil(y+r+1))))
+	}
+	area = area.Intersect(b)
+	ox, oy := float64(area.Min.X), float64(area.Min.Y)
+
+	fill(dst, area, c, func(z *vector.Rasterizer) {
+		for i, p := range points {
+			// the joins and ends are circles, which are wound the same way as the segments, so that they add up where they overlap
+			if p[0] >= clip[0] && p[0] <= clip[2] && p[1] >= clip[1] && p[1] <= clip[3] {
+				circle(z, p[0]-ox, p[1]-oy, r)
+			}
+			if i == 0 {
+				continue
+			}
+
+			a, e, ok := clipSegment(points[i-1], p, clip)
+			length := math.Hypot(e[0]-a[0], e[1]-a[1])
+			if !ok || length == 0 {
+				continue
+			}
+
+			// the segment is a rectangle around the line between a and e
+			nx, ny := -(e[1]-a[1])/length*r, (e[0]-a[0])/length*r
+			z.MoveTo(float32(a[0]-nx-ox), float32(a[1]-ny-oy))
+			z.LineTo(float32(e[0]-nx-ox), float32(e[1]-ny-oy))
+			z.LineTo(float32(e[0]+nx-ox), float32(e[1]+ny-oy))
+			z.LineTo(float32(a[0]+nx-ox), float32(a[1]+ny-oy))
+			z.ClosePath()
+		}
+	})
+}
+
+// clipSegment returns the part of the line segment from a to e that is inside clip, given as minX, minY, maxX and maxY,
+// or false if none of it is. It is the algorithm of Liang and Barsky.
+func clipSegment(a, e [2]float64, clip [4]float64) ([2]float64, [2]float64, bool) {
+	dx, dy := e[0]-a[0], e[1]-a[1]
+	t0, t1 := 0.0, 1.0
+
+	// each edge of clip is p*t <= q, for the points a + t*(dx, dy) of the segment
+	for _, edge := range [4][2]float64{{-dx, a[0] - clip[0]}, {dx, clip[2] - a[0]}, {-dy, a[1] - clip[1]}, {dy, clip[3] - a[1]}} {
+		p, q := edge[0], edge[1]
+		if p == 0 {
+			if q < 0 {
+				return a, e, false
+			}
+			continue
+		}
+		t := q / p
+		if p < 0 {
+			t0 = math.Max(t0, t)
+		} else {
+			t1 = math.Min(t1, t)
+		}
+	}
+	if t0 > t1 {
+		return a, e, false
+	}
+
+	return [2]float64{a[0] + t0*dx, a[1] + t0*dy}, [2]float64{a[0] + t1*dx, a[1] + t1*dy}, true
+}
+
+// fillRings fills the polygon made of rings of dst with c, anti-aliased, where the first ring is the outline and the others are holes in it.
+// The polygon is filled in one go, like stroke does with lines.
+func fillRings(dst *image.RGBA, rings [][][2]float64, c color.Color) {
+	// the rings are clipped to just outside dst for the same reason as the segments in stroke
+	b := dst.Bounds()
+	clip := [4]float64{float64(b.Min.X) - 1, float64(b.Min.Y) - 1, float64(b.Max.X) + 1, float64(b.Max.Y) + 1}
+
+	area := image.Rectangle{}
+	var clipped [][][2]float64
+	for i, ring := range rings {
+		ring = clipRing(ring, clip)
+		a := ringArea(ring)
+		if len(ring) < 3 || a == 0 {
+			continue
+		}
+
+		// The rasterizer adds up the windings of the rings and fills where they do not add up to zero,
+		// so the outline is wound one way and the holes the other, whichever way they were given.
+		if (i == 0) != (a > 0) {
+			reversed := make([][2]float64, len(ring))
+			for j, p := range ring {
+				reversed[len(ring)-1-j] = p
+			}
+			ring = reversed
+		}
+		clipped = append(clipped, ring)
+
+		for _, p := range ring {
+			area = area.Union(image.Rect(int(math.Floor(p[0])), int(math.Floor(p[1])), int(math.Ceil(p[0]))+1, int(math.Ceil(p[1]))+1))
+		}
+	}
+	area = area.Intersect(b)
+	ox, oy := float64(area.Min.X), float64(area.Min.Y)
+
+	fill(dst, area, c, func(z *vector.Rasterizer) {
+		for _, ring := range clipped {
+			z.MoveTo(float32(ring[0][0]-ox), float32(ring[0][1]-oy))
+			for _, p := range ring[1:] {
+				z.LineTo(float32(p[0]-ox), float32(p[1]-oy))
+			}
+			z.ClosePath()
+		}
+	})
+}
+
+// ringArea returns the area of ring, which is positive if it goes clockwise in images, where y goes down, like circle does
+func ringArea(ring [][2]float64) float64 {
+	a := 0.0
+	for i, p := range ring {
+		q := ring[(i+1)%len(ring)]
+		a += p[0]*q[1] - q[0]*p[1]
+	}
+	return a / 2
+}
+
+// clipRing returns the part of the closed ring that is inside clip, given as minX, minY, maxX and maxY.
+// It is the algorithm of Sutherland and Hodgman, which adds points along the edges of clip where the ring goes outside it.
+func clipRing(ring [][2]float64, clip [4]float64) [][2]float64 {
+	for edge, v := range clip {
+		if len(ring) == 0 {
+			break
+		}
+
+		// the edges are x and y in turn, and the first two are minimums
+		axis := edge % 2
+		inside := func(p [2]float64) bool {
+			if edge < 2 {
+				return p[axis] >= v
+			}
+			return p[axis] <= v
+		}
+		cross := func(a, e [2]float64) [2]float64 {
+			t := (v - a[axis]) / (e[axis] - a[axis])
+			return [2]float64{a[0] + t*(e[0]-a[0]), a[1] + t*(e[1]-a[1])}
+		}
+
+		var out [][2]float64
+		prev := ring[len(ring)-1]
+		for _, p := range ring {
+			if inside(p) {
+				if !inside(prev) {
+					out = append(out, cross(prev, p))
+				}
+				out = append(out, p)
+			} else if inside(prev) {
+				out = append(out, cross(prev, p))
+			}
+			prev = p
+		}
+		ring = out
+	}
+	return ring
+}
@@ -2,13 +2,13 @@ package stitch
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
 	"image"
 	"image/color"
-	"image/draw"
 	"image/png"
 	"os"
 	"path/filepath"
@@ -19,62 +19,144 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/krilor/slipee/internal/tile"
+	"golang.org/x/image/draw"
 	"golang.org/x/image/font"
 	"golang.org/x/image/font/inconsolata"
 	"golang.org/x/image/math/fixed"
 )
 
 // Package stitch implements the tile stitching operations
-// It also controls the tile server access limitiations
-// E.g. OSM tile servers should not be overloaded, and there is a maximum thread limit at 2.
+// The tile server access limitiations are controlled by the tile package.
+// E.g. OSM tile servers should not be overloaded, so there is a maximum thread limit at 2, and a rate limit.
 
 // Request is a structure that holds all vars that make up a request
-type request struct {
-	width  int
-	height int
-	zoom   int
-	lat    float64
-	long   float64
-	label  string
+type Request struct {
+	Width  int
+	Height int
+	// Zoom may be fractional
+	Zoom  float64
+	Lat   float64
+	Long  float64
+	Label string
+	// Scale is the pixel density of the image, e.g. 2 for high-DPI screens. Zero means 1.
+	Scale int
+	// Layers are stacked to make the map, with the first layer at the bottom. If empty, the source of the stitcher is used.
+	Layers []tile.Layer
+	// Markers are drawn on the map, see ParseMarkers. If empty, a single pin is drawn at the center.
+	Markers []Marker
+	// Paths are drawn on the map below the markers, see ParsePaths
+	Paths []Path
+	// Polygons are drawn on the map below the paths, see ParsePolygons
+	Polygons []Polygon
+	// Circles are drawn on the map above the polygons, see ParseCircles
+	Circles []Circle
 }
 
 // Hash returns a hash string of the request, that can be used in caching type operations
-func (r request) hash() string {
+func (r Request) hash() string {
 	hash := sha1.New()
 
 	// errors are ignored on purpose. hash.Hash docs specifically state that the writer "never returns an error."
-	binary.Write(hash, binary.LittleEndian, r.width)
-	binary.Write(hash, binary.LittleEndian, r.height)
-	binary.Write(hash, binary.LittleEndian, r.zoom)
-	binary.Write(hash, binary.LittleEndian, r.lat)
-	binary.Write(hash, binary.LittleEndian, r.long)
+	// ints are written as int64, as binary.Write only handles fixed size values
+	binary.Write(hash, binary.LittleEndian, int64(r.Width))
+	binary.Write(hash, binary.LittleEndian, int64(r.Height))
+	binary.Write(hash, binary.LittleEndian, r.Zoom)
+	binary.Write(hash, binary.LittleEndian, r.Lat)
+	binary.Write(hash, binary.LittleEndian, r.Long)
+	binary.Write(hash, binary.LittleEndian, int64(r.scale()))
+
+	hash.Write([]byte(r.Label))
+
+	// layers are written by name, as the sources themselves are not comparable.
+	// names are zero terminated, so that e.g. "ab","c" and "a","bc" are different.
+	for _, l := range r.Layers {
+		hash.Write(append([]byte(l.Name), 0))
+		binary.Write(hash, binary.LittleEndian, l.Opacity)
+		hash.Write(append([]byte(l.Blend), 0))
+	}
+
+	// custom icons are written by name, like layers
+	for _, m := range r.Markers {
+		binary.Write(hash, binary.LittleEndian, m.Lat)
+		binary.Write(hash, binary.LittleEndian, m.Long)
+		hash.Write([]byte{m.Color.R, m.Color.G, m.Color.B, m.Color.A})
+		binary.Write(hash, binary.LittleEndian, int64(m.Size))
+		binary.Write(hash, binary.LittleEndian, m.AnchorX)
+		binary.Write(hash, binary.LittleEndian, m.AnchorY)
+		hash.Write(append([]byte(m.Label), 0))
+		hash.Write(append([]byte(m.Icon), 0))
+	}
+
+	// the number of points is written first, so that the points of one path can not be taken for those of another
+	for _, p := range r.Paths {
+		binary.Write(hash, binary.LittleEndian, int64(len(p.Points)))
+		binary.Write(hash, binary.LittleEndian, p.Points)
+		hash.Write([]byte{p.Color.R, p.Color.G, p.Color.B, p.Color.A})
+		binary.Write(hash, binary.LittleEndian, p.Width)
+	}
+
+	for _, p := range r.Polygons {
+		binary.Write(hash, binary.LittleEndian, int64(len(p.Rings)))
+		for _, ring := range p.Rings {
+			binary.Write(hash, binary.LittleEndian, int64(len(ring)))
+			binary.Write(hash, binary.LittleEndian, ring)
+		}
+		hash.Write([]byte{p.Fill.R, p.Fill.G, p.Fill.B, p.Fill.A, p.Color.R, p.Color.G, p.Color.B, p.Color.A})
+		binary.Write(hash, binary.LittleEndian, p.Width)
+	}
 
-	hash.Write([]byte(r.label))
+	for _, c := range r.Circles {
+		binary.Write(hash, binary.LittleEndian, [3]float64{c.Lat, c.Long, c.Radius})
+		hash.Write([]byte{c.Fill.R, c.Fill.G, c.Fill.B, c.Fill.A, c.Color.R, c.Color.G, c.Color.B, c.Color.A})
+		binary.Write(hash, binary.LittleEndian, c.Width)
+	}
 
 	return hex.EncodeToString(hash.Sum(nil))
 }
 
-func (r request) path() string {
+// scale returns the scale of the request, defaulting to 1
+func (r Request) scale() int {
+	if r.Scale < 1 {
+		return 1
+	}
+	return r.Scale
+}
+
+func (r Request) path() string {
 	h := r.hash()
 	return filepath.Join(h[:2], h[2:]+".png")
 
 }
 
-// Stitcher interface
+// degradedPath is where a degraded image of the request is cached, next to the complete one at path
+func (r Request) degradedPath() string {
+	h := r.hash()
+	return filepath.Join(h[:2], h[2:]+".degraded.png")
+}
+
+// Stitcher interface.
+// Degraded images are the ones with placeholders for tiles that could not be had, see New.
 type Stitcher interface {
-	Stitch(width int, height int, zoom int, lat float64, long float64, label string) string
-	Queue(width int, height int, zoom int, lat float64, long float64, label string) error
-	StaticImage(width int, height int, zoom int, lat float64, long float64, label string) (string, error)
+	Stitch(r Request) (path string, degraded bool)
+	Queue(r Request) error
+	StaticImage(ctx context.Context, r Request) (path string, degraded bool, err error)
 	StartWorker()
 }
 
-// New returns a new Stitcher for the tile server s.
-// Size is the size of the queue buffer
-func New(server *tile.Server, size int, cachePath string) Stitcher {
+// New returns a new Stitcher for the tile source src.
+// Size is the size of the queue buffer. Timeout is the deadline for making an image, or 0 for no deadline.
+//
+// If placeholder is set, images are made even if some of their tiles can not be had, with placeholder in place of those tiles.
+// Such images are degraded, and are made again when they are asked for after retry, in the hope that the tiles are back.
+// If placeholder is empty, images fail if any tile fails.
+func New(src tile.TileSource, size int, cachePath string, timeout time.Duration, placeholder tile.Placeholder, retry time.Duration) Stitcher {
 	s = stitch{
-		server,
-		make(chan request, size),
+		src,
+		make(chan Request, size),
 		cachePath,
+		timeout,
+		placeholder,
+		retry,
 	}
 
 	return &s
@@ -91,50 +173,47 @@ func Get() (Stitcher, error) {
 
 // stitch is a struct that implements the stitcher interface
 type stitch struct {
-	server *tile.Server
-	queue  chan request
-	cache  string
+	source      tile.TileSource
+	queue       chan Request
+	cache       string
+	timeout     time.Duration
+	placeholder tile.Placeholder
+	retry       time.Duration
 }
 
 // stitch is using a singleton pattern
 var s stitch
 
-// Get gets the path to a stitched static image
-func (s *stitch) Stitch(width int, height int, zoom int, lat float64, long float64, label string) string {
-	r := request{
-		width,
-		height,
-		zoom,
-		lat,
-		long,
-		label,
-	}
-
+// Get gets the path to a stitched static image, and if it is degraded.
+// Degraded images older than the retry of the stitcher are queued to be made again, and are used until then.
+func (s *stitch) Stitch(r Request) (string, bool) {
 	path := filepath.Join(s.cache, r.path())
 
 	if _, err := os.Stat(path); err == nil {
-		return path
+		return path, false
+	}
+
+	degraded := filepath.Join(s.cache, r.degradedPath())
+	if info, err := os.Stat(degraded); err == nil {
+		if time.Since(info.ModTime()) > s.retry {
+			// touched, so that it is not queued again for every request while it is being made
+			now := time.Now()
+			os.Chtimes(degraded, now, now)
+			s.Queue(r)
+		}
+		return degraded, true
 	}
 
 	// error is ignored on purpose
-	s.Queue(width, height, zoom, lat, long, label)
+	s.Queue(r)
 
-	return ""
+	return "", false
 
 }
 
 // Queue queues a request for later pickup
 // Error is returned if channel is blocking (buffer is full)
-func (s *stitch) Queue(width int, height int, zoom int, lat float64, long float64, label string) error {
-	r := request{
-		width,
-		height,
-		zoom,
-		lat,
-		long,
-		label,
-	}
-
+func (s *stitch) Queue(r Request) error {
 	path := filepath.Join(s.cache, r.path())
 
 	if _, err := os.Stat(path); err == nil {
@@ -151,33 +230,54 @@ func (s *stitch) Queue(width int, height int, zoom int, lat float64, long float6
 
 }
 
-// StaticImage creates a static image
-func (s *stitch) StaticImage(width int, height int, zoom int, lat float64, long float64, label string) (string, error) {
-
-	r := request{
-		width,
-		height,
-		zoom,
-		lat,
-		long,
-		label,
+// StaticImage creates a static image, and reports if it is degraded.
+// It is given up when ctx is done, e.g. when the client has gone away, or when the timeout of the stitcher has passed.
+func (s *stitch) StaticImage(ctx context.Context, r Request) (string, bool, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
 	}
+
 	path := filepath.Join(s.cache, r.path())
+	degradedPath := filepath.Join(s.cache, r.degradedPath())
 
 	os.MkdirAll(filepath.Dir(path), os.ModePerm) // TODO check err
 
-	img, err := s.server.StaticMap(width, height, zoom, lat, long)
+	src := s.source
+	if len(r.Layers) > 0 {
+		src = tile.NewComposite(r.Layers...)
+	}
+
+	var img *image.RGBA
+	var failed []error
+	var err error
+	if s.placeholder != "" {
+		img, failed, err = tile.PartialMap(ctx, src, r.Width, r.Height, r.Zoom, r.scale(), r.Lat, r.Long, s.placeholder)
+	} else {
+		img, err = tile.StaticMap(ctx, src, r.Width, r.Height, r.Zoom, r.scale(), r.Lat, r.Long)
+	}
 	if err != nil {
-		return "", errors.Wrap(err, "an error occurred while getting staticmap")
+		return "", false, errors.Wrap(err, "an error occurred while getting staticmap")
 	}
 
-	// to embed another PNG marker, use the following command in your terminal
-	// cat some-marker-24.png | base64 -w 0 | xclip -sel clip
-	data, _ := base64.StdEncoding.DecodeString("iVBORw0KGgoAAAANSUhEUgAAABgAAAAYCAYAAADgdz34AAAABmJLR0QA/wD/AP+gvaeTAAABJElEQVRIieXUPUoDQRjG8R8qaKcgBsHKGPAAFoK2HkE9Qu5grXcQWysjaBsrrVIavYFFWkGNFpoiWuwGlt3ZuJtNIz7wws687/yf+dgZ/oNqOMEDPuLo4jjOVdIB+vjOiT72q8CHY+CjGE5iUvtl5ul4w0oINJtjcIS9VN8rznGPBhYSuXl84q7oCh5TM3zBeiJfjw2TNd2icHhPDT4N1JzJHnhGMzkGXwXq0n2DHFZQHdktqifyG7Jb1AmB5nIMbrGTaC+J9vgybh9iMTCmsBqK3YFkbJYxgHYJeLssHLZKrGJ7EgNoFYC3JoXDqugPGvdErFUxgOYYg2ZV+EgXAfjVtOBE9+ApAe9heZoGsCt6DgbxdyHlPdch9fCMG1yXmtqf1g/2CJPvQAzABQAAAABJRU5ErkJggg==")
-	marker, _ := png.Decode(bytes.NewReader(data))
+	degraded := len(failed) > 0
+	if degraded {
+		log.Printf("staticimage for r: %+v is degraded, as %d tiles failed, the first due to: %s", r, len(failed), failed[0])
+		path = degradedPath
+	}
+
+	pixel := tile.Pixels(src, r.Width, r.Height, r.Zoom, r.scale(), r.Lat, r.Long)
+	drawAreas(img, r.Polygons, r.Circles, r.scale(), pixel)
+	drawPaths(img, r.Paths, r.scale(), pixel)
 
-	addLabel(img, label)
-	addMarker(img, marker)
+	if len(r.Markers) > 0 {
+		drawMarkers(img, r.Markers, r.scale(), pixel)
+	} else {
+		addCenterMarker(img, r.scale())
+	}
+
+	addLabel(img, r.Label, r.scale())
 
 	enc := png.Encoder{
 		CompressionLevel: png.BestSpeed,
@@ -185,7 +285,7 @@ func (s *stitch) StaticImage(width int, height int, zoom int, lat float64, long
 
 	f, err := os.Create(path)
 	if err != nil {
-		return "", errors.Wrapf(err, "could not create file %s", path)
+		return "", false, errors.Wrapf(err, "could not create file %s", path)
 	}
 
 	err = enc.Encode(f, img)
@@ -193,25 +293,28 @@ func (s *stitch) StaticImage(width int, height int, zoom int, lat float64, long
 
 	if err != nil {
 		os.Remove(path)
-		return "", errors.Wrapf(err, "could not encode image")
+		return "", false, errors.Wrapf(err, "could not encode image")
+	}
+
+	if !degraded {
+		// the complete image replaces the degraded one, if there was one
+		os.Remove(degradedPath)
 	}
 
-	return path, nil
+	return path, degraded, nil
 }
 
 // StartWorker spins of a goroutine that has a worker creating images off the queue
 func (s *stitch) StartWorker() {
 	go func(s *stitch) {
 		for r := range s.queue {
-			_, err := s.StaticImage(r.width, r.height, r.zoom, r.lat, r.long, r.label)
+			_, _, err := s.StaticImage(context.Background(), r)
 
 			if err != nil {
 				log.Printf("could not create staticimage for r: %+v due to: %s", r, err)
 			} else {
 				log.Printf("staticimage created for r: %+v", r)
 			}
-
-			time.Sleep(time.Second)
 		}
 
 	}(s)
@@ -219,16 +322,19 @@ func (s *stitch) StartWorker() {
 }
 
 // addLabel is based on https://stackoverflow.com/a/38300583
-func addLabel(img *image.RGBA, label string) {
+// The label is drawn in normal size, and scaled up to the pixel density of img.
+func addLabel(img *image.RGBA, label string, scale int) {
 
 	b := img.Bounds()
 	width := b.Dx()
 	height := b.Dy()
 
+	l := image.NewRGBA(image.Rectangle{image.Point{0, 0}, image.Point{len(label)*8 + 16, 28}})
+
 	// adds white area for label
 	draw.DrawMask(
-		img,
-		image.Rectangle{image.Point{width - len(label)*8 - 16, height - 28}, image.Point{width, height}},
+		l,
+		l.Bounds(),
 		&image.Uniform{color.RGBA{255, 255, 255, 255}}, // white
 		image.Point{0, 0},
 		&image.Uniform{color.Alpha{196}},
@@ -236,8 +342,8 @@ func addLabel(img *image.RGBA, label string) {
 		draw.Over,
 	)
 
-	x := width - (len(label))*8
-	y := height - 8
+	x := 16
+	y := l.Bounds().Dy() - 8
 
 	col := color.RGBA{0, 0, 0, 255}
 
@@ -245,25 +351,49 @@ func addLabel(img *image.RGBA, label string) {
 	point := fixed.Point26_6{X: fixed.Int26_6(x * 64), Y: fixed.Int26_6(y * 64)}
 
 	d := &font.Drawer{
-		Dst:  img,
+		Dst:  l,
 		Src:  image.NewUniform(col),
 		Face: inconsolata.Regular8x16,
 		Dot:  point,
 	}
 	d.DrawString(label)
+
+	size := l.Bounds().Size().Mul(scale)
+	draw.NearestNeighbor.Scale(
+		img,
+		image.Rectangle{image.Point{width - size.X, height - size.Y}, image.Point{width, height}},
+		l,
+		l.Bounds(),
+		draw.Over,
+		nil,
+	)
 }
 
-func addMarker(img *image.RGBA, marker image.Image) {
+// addCenterMarker draws the embedded pin at the center of img, for maps without markers
+func addCenterMarker(img *image.RGBA, scale int) {
+	// to embed another PNG marker, use the following command in your terminal
+	// cat some-marker-24.png | base64 -w 0 | xclip -sel clip
+	data, _ := base64.StdEncoding.DecodeString("iVBORw0KGgoAAAANSUhEUgAAABgAAAAYCAYAAADgdz34AAAABmJLR0QA/wD/AP+gvaeTAAABJElEQVRIieXUPUoDQRjG8R8qaKcgBsHKGPAAFoK2HkE9Qu5grXcQWysjaBsrrVIavYFFWkGNFpoiWuwGlt3ZuJtNIz7wws687/yf+dgZ/oNqOMEDPuLo4jjOVdIB+vjOiT72q8CHY+CjGE5iUvtl5ul4w0oINJtjcIS9VN8rznGPBhYSuXl84q7oCh5TM3zBeiJfjw2TNd2icHhPDT4N1JzJHnhGMzkGXwXq0n2DHFZQHdktqifyG7Jb1AmB5nIMbrGTaC+J9vgybh9iMTCmsBqK3YFkbJYxgHYJeLssHLZKrGJ7EgNoFYC3JoXDqugPGvdErFUxgOYYg2ZV+EgXAfjVtOBE9+ApAe9heZoGsCt6DgbxdyHlPdch9fCMG1yXmtqf1g/2CJPvQAzABQAAAABJRU5ErkJggg==")
+	marker, _ := png.Decode(bytes.NewReader(data))
+
+	addMarker(img, marker, scale)
+}
+
+// addMarker draws marker at the center of img, scaled to the pixel density of img
+func addMarker(img *image.RGBA, marker image.Image, scale int) {
 
 	b := img.Bounds()
 	width := b.Dx()
 	height := b.Dy()
 
-	draw.Draw(
+	size := marker.Bounds().Size().Mul(scale)
+
+	draw.ApproxBiLinear.Scale(
 		img,
-		image.Rectangle{image.Point{width/2 - 12, height/2 - 12}, image.Point{width, height}},
+		image.Rectangle{image.Point{width/2 - size.X/2, height/2 - size.Y/2}, image.Point{width/2 + size.X/2, height/2 + size.Y/2}},
 		marker,
-		image.Point{0, 0},
+		marker.Bounds(),
 		draw.Over,
+		nil,
 	)
 }
@@ -0,0 +1,87 @@
+package tile
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// apiKeyVariable matches the ${apikey} variable in urls and header values
+var apiKeyVariable = regexp.MustCompile(`\$?{(?i:apikey)}`)
+
+// fillAPIKey replaces ${apikey} in s with key, which is url escaped if s is a url.
+// It is an error if s has the variable but key is empty.
+func fillAPIKey(s, key string, isURL bool) (string, error) {
+	if !apiKeyVariable.MatchString(s) {
+		return s, nil
+	}
+	if key == "" {
+		return "", errors.New("${apikey} is used, but no api key is set")
+	}
+	if isURL {
+		key = url.QueryEscape(key)
+	}
+	return apiKeyVariable.ReplaceAllLiteralString(s, key), nil
+}
+
+// ParseHeaders parses headers given as "Name: value" pairs separated by |, e.g. "Authorization: Bearer ${apikey}|X-Client: slipee"
+func ParseHeaders(s string) (map[string]string, error) {
+	headers := map[string]string{}
+
+	for _, pair := range strings.Split(s, "|") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, ":", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("header '%s' is not on the form 'Name: value'", pair)
+		}
+
+		headers[name] = strings.TrimSpace(parts[1])
+	}
+
+	return headers, nil
+}
+
+// redactedError is an error with secrets, like api keys, removed from its message
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the original error, so that e.g. errors.Is(err, ErrNotFound) still works
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redact returns err with secrets removed from its message. Secrets are also removed in their url escaped forms.
+func redact(err error, secrets ...string) error {
+	if err == nil {
+		return nil
+	}
+
+	msg := err.Error()
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		for _, s := range []string{secret, url.QueryEscape(secret), url.PathEscape(secret)} {
+			msg = strings.Replace(msg, s, "REDACTED", -1)
+		}
+	}
+
+	if msg == err.Error() {
+		return err
+	}
+
+	return &redactedError{err, msg}
+}
@@ -0,0 +1,143 @@
+package tile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseHeaders(t *testing.T) {
+	var headersTest = []struct {
+		in   string
+		want map[string]string
+		ok   bool
+	}{
+		{"", map[string]string{}, true},
+		{"Authorization: Bearer ${apikey}", map[string]string{"Authorization": "Bearer ${apikey}"}, true},
+		{"X-A: 1 | X-B: a:b", map[string]string{"X-A": "1", "X-B": "a:b"}, true},
+		{"X-A", nil, false},
+		{": 1", nil, false},
+		{"X A: 1", nil, false},
+	}
+
+	for _, test := range headersTest {
+		t.Run(test.in, func(t *testing.T) {
+			got, err := ParseHeaders(test.in)
+			if (err == nil) != test.ok {
+				t.Fatalf("got error %v - want ok %t", err, test.ok)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %v - want %v", got, test.want)
+			}
+			for name, value := range test.want {
+				if got[name] != value {
+					t.Errorf("got %s: %s - want %s", name, got[name], value)
+				}
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	err := redact(errors.New("got status code 500 for https://example.com/1/2/3.png?key=s%C3%A6cret"), "sæcret")
+	if strings.Contains(err.Error(), "cret") {
+		t.Errorf("got %s - want the key redacted", err)
+	}
+
+	err = redact(fmt.Errorf("got status code 404 for https://example.com/?key=secret: %w", ErrNotFound), "secret")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v - want it to still be ErrNotFound", err)
+	}
+
+	plain := errors.New("nothing to hide")
+	if redact(plain, "secret") != plain {
+		t.Errorf("got a new error when there is nothing to redact")
+	}
+}
+
+func TestServerHeaders(t *testing.T) {
+	var got *http.Request
+	status := http.StatusOK
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		png.Encode(w, image.NewRGBA(image.Rect(0, 0, 256, 256)))
+	}))
+	defer ts.Close()
+
+	src, err := NewServer(ts.URL+"/{z}/{x}/{y}.png?key={apikey}",
+		WithAPIKey("s3cr3t&"),
+		WithUserAgent("Slipee/1.2.3 (+https://github.com/krilor/slipee)"),
+		WithReferer("https://example.com/"),
+		WithHeaders(map[string]string{"Authorization": "Bearer ${apikey}"}),
+		WithHeaders(map[string]string{"X-Client": "slipee"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = src.Get(context.Background(), 1, 1, 1)
+	if err != nil {
+		t.Fatalf("got error %s", err)
+	}
+
+	want := map[string]string{
+		"User-Agent":    "Slipee/1.2.3 (+https://github.com/krilor/slipee)",
+		"Referer":       "https://example.com/",
+		"Authorization": "Bearer s3cr3t&",
+		"X-Client":      "slipee",
+	}
+	for name, value := range want {
+		if got.Header.Get(name) != value {
+			t.Errorf("got %s: %s - want %s", name, got.Header.Get(name), value)
+		}
+	}
+	if key := got.URL.Query().Get("key"); key != "s3cr3t&" {
+		t.Errorf("got key %s in the url - want s3cr3t&", key)
+	}
+
+	status = http.StatusForbidden
+	_, err = src.Get(context.Background(), 1, 1, 0)
+	if err == nil || strings.Contains(err.Error(), "s3cr3t") {
+		t.Errorf("got error '%v' - want an error without the key", err)
+	}
+
+	_, err = NewServer(ts.URL + "/{z}/{x}/{y}.png?key={apikey}")
+	if err == nil {
+		t.Errorf("got no error for ${apikey} without an api key")
+	}
+}
+
+func TestServerNotImageRedacted(t *testing.T) {
+	// an error page that echoes the request, sent as an image or with no Content-Type, is only found out by decode
+	for name, contentType := range map[string]string{"image": "image/png", "none": ""} {
+		contentType := contentType
+		t.Run(name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header()["Content-Type"] = []string{contentType}
+				fmt.Fprintf(w, "<html><body>No tile at %s</body></html>", r.URL)
+			}))
+			defer ts.Close()
+
+			src, err := NewServer(ts.URL+"/{z}/{x}/{y}.png?key={apikey}", WithAPIKey("s3cr3t"), WithRateLimit(0, 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = src.Get(context.Background(), 1, 1, 0)
+			if err == nil || !strings.Contains(err.Error(), "not an image") || strings.Contains(err.Error(), "s3cr3t") {
+				t.Errorf("got error '%v' - want a not an image error without the key", err)
+			}
+		})
+	}
+}
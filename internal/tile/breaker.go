@@ -0,0 +1,87 @@
+package tile
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// breakerFailures is the number of failures in a row that trips a circuit breaker
+	breakerFailures = 3
+
+	// breakerCooldown is how long a tripped circuit breaker stops requests, before it lets a trial request through
+	breakerCooldown = 30 * time.Second
+)
+
+// breaker is a circuit breaker for a tile server mirror.
+// It is closed, letting requests through, until there are breakerFailures failures in a row.
+// Then it is open, stopping requests, for breakerCooldown. After that, a single trial request is let through,
+// which closes the breaker if it succeeds and opens it again if it fails. A trial request that is given up does neither, see abort.
+// Only the trial request itself ends the trial, as requests sent before the breaker opened can end while it is in flight.
+type breaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool // a trial request is in flight
+}
+
+// allow reports if a request may be sent, and if it is the trial request.
+// The trial bool must be passed on to success, failure or abort when the request ends.
+func (b *breaker) allow(now time.Time) (ok, trial bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < breakerFailures {
+		return true, false
+	}
+
+	if now.Before(b.openUntil) || b.trial {
+		return false, false
+	}
+
+	b.trial = true
+	return true, true
+}
+
+// success records a successful request, and reports if it closed the breaker
+func (b *breaker) success(trial bool) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	closed := b.failures >= breakerFailures
+	b.failures = 0
+	if trial {
+		b.trial = false
+	}
+
+	return closed
+}
+
+// failure records a failed request, and reports if it opened the breaker
+func (b *breaker) failure(now time.Time, trial bool) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	opened := b.failures == breakerFailures || trial
+	if trial {
+		b.trial = false
+	}
+
+	if b.failures >= breakerFailures {
+		b.openUntil = now.Add(breakerCooldown)
+	}
+
+	return opened
+}
+
+// abort records a request that was given up before it could tell if the mirror works, e.g. as ctx is done.
+// If it was the trial request, the next request after the cooldown is the trial instead.
+func (b *breaker) abort(trial bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if trial {
+		b.trial = false
+	}
+}
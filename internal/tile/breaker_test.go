@@ -0,0 +1,216 @@
+package tile
+
+import (
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	b := breaker{}
+
+	for i := 0; i < breakerFailures; i++ {
+		if ok, _ := b.allow(now); !ok {
+			t.Fatalf("got request %d stopped - want it let through", i)
+		}
+		if opened := b.failure(now, false); opened != (i == breakerFailures-1) {
+			t.Errorf("got opened %t after %d failures", opened, i+1)
+		}
+	}
+
+	if ok, _ := b.allow(now.Add(breakerCooldown / 2)); ok {
+		t.Errorf("got request let through while open")
+	}
+
+	// after the cooldown, a single trial request is let through
+	later := now.Add(breakerCooldown)
+	if ok, trial := b.allow(later); !ok || !trial {
+		t.Errorf("got trial request stopped after the cooldown")
+	}
+	if ok, _ := b.allow(later); ok {
+		t.Errorf("got second request let through while the trial is in flight")
+	}
+
+	if !b.failure(later, true) {
+		t.Errorf("got failed trial not opening the breaker")
+	}
+	if ok, _ := b.allow(later); ok {
+		t.Errorf("got request let through after the failed trial")
+	}
+
+	evenLater := later.Add(breakerCooldown)
+	if ok, trial := b.allow(evenLater); !ok || !trial {
+		t.Errorf("got trial request stopped after the second cooldown")
+	}
+	if !b.success(true) {
+		t.Errorf("got successful trial not closing the breaker")
+	}
+	if ok, trial := b.allow(evenLater); !ok || trial {
+		t.Errorf("got request stopped, or taken as a trial, after the breaker closed")
+	}
+}
+
+func TestBreakerLateRequests(t *testing.T) {
+	now := time.Now()
+	b := breaker{}
+
+	// requests sent before the breaker opened end while the trial is in flight, and must not end the trial
+	for i := 0; i < breakerFailures; i++ {
+		b.allow(now)
+	}
+	for i := 0; i < breakerFailures; i++ {
+		b.failure(now, false)
+	}
+
+	later := now.Add(breakerCooldown)
+	if ok, trial := b.allow(later); !ok || !trial {
+		t.Fatalf("got trial request stopped after the cooldown")
+	}
+
+	b.abort(false)
+	if ok, _ := b.allow(later); ok {
+		t.Errorf("got a second trial let through after a request that was not the trial was given up")
+	}
+
+	if b.failure(later, false) {
+		t.Errorf("got a late failure that was not the trial reported as opening the breaker")
+	}
+	if ok, _ := b.allow(later.Add(breakerCooldown)); ok {
+		t.Errorf("got a second trial let through after a request that was not the trial failed")
+	}
+
+	b.abort(true)
+	if ok, trial := b.allow(later.Add(breakerCooldown)); !ok || !trial {
+		t.Errorf("got no new trial after the trial was given up")
+	}
+}
+
+func TestServerMirrors(t *testing.T) {
+	mu := sync.Mutex{}
+	requests := map[string]int{}
+
+	handler := func(name string, status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			requests[name]++
+			mu.Unlock()
+
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+			png.Encode(w, image.NewRGBA(image.Rect(0, 0, 256, 256)))
+		}
+	}
+
+	good := httptest.NewServer(handler("good", http.StatusOK))
+	defer good.Close()
+	bad := httptest.NewServer(handler("bad", http.StatusInternalServerError))
+	defer bad.Close()
+	other := httptest.NewServer(handler("other", http.StatusOK))
+	defer other.Close()
+
+	src, err := NewServer(bad.URL+"/{z}/{x}/{y}.png "+good.URL+"/{z}/{x}/{y}.png\n"+other.URL+"/{z}/{x}/{y}.png", WithRateLimit(0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for x := 0; x < 16; x++ {
+		_, err := src.Get(context.Background(), 4, x, 0)
+		if err != nil {
+			t.Fatalf("got error %s", err)
+		}
+	}
+
+	if requests["bad"] != breakerFailures {
+		t.Errorf("got %d requests to the failing mirror - want %d", requests["bad"], breakerFailures)
+	}
+	if requests["good"] < 4 || requests["other"] < 4 {
+		t.Errorf("got requests %v - want them spread over the working mirrors", requests)
+	}
+}
+
+func TestServerMirrorsTrialCanceled(t *testing.T) {
+	mu := sync.Mutex{}
+	status := http.StatusInternalServerError
+	requests := 0
+
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		s := status
+		mu.Unlock()
+
+		switch s {
+		case http.StatusOK:
+			png.Encode(w, image.NewRGBA(image.Rect(0, 0, 256, 256)))
+		case 0:
+			// hang until the request is given up
+			<-r.Context().Done()
+		default:
+			w.WriteHeader(s)
+		}
+	}))
+	defer flaky.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		png.Encode(w, image.NewRGBA(image.Rect(0, 0, 256, 256)))
+	}))
+	defer good.Close()
+
+	src, err := NewServer(flaky.URL+"/{z}/{x}/{y}.png "+good.URL+"/{z}/{x}/{y}.png", WithRateLimit(0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &src.fetcher.mirrors[0].breaker
+
+	// get starts with the mirror after next, which is the flaky one when next is odd
+	get := func(ctx context.Context) error {
+		src.fetcher.next = 1
+		_, err := src.Get(ctx, 4, 0, 0)
+		return err
+	}
+
+	for i := 0; i < breakerFailures; i++ {
+		if err := get(context.Background()); err != nil {
+			t.Fatalf("got error %s", err)
+		}
+	}
+
+	// the cooldown is over, and the trial request is given up while the mirror hangs
+	b.mu.Lock()
+	b.openUntil = time.Now()
+	b.mu.Unlock()
+	mu.Lock()
+	status = 0
+	mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := get(ctx); err == nil {
+		t.Fatalf("got no error for the canceled trial")
+	}
+
+	// the next request is the trial instead, and the mirror is back up
+	mu.Lock()
+	status = http.StatusOK
+	before := requests
+	mu.Unlock()
+
+	if err := get(context.Background()); err != nil {
+		t.Fatalf("got error %s", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != before+1 {
+		t.Errorf("got the mirror left out after a canceled trial - want a new trial")
+	}
+	if ok, _ := b.allow(time.Now()); !ok {
+		t.Errorf("got the breaker open after a successful trial")
+	}
+}
@@ -0,0 +1,222 @@
+package tile
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// diskCache is a raw tile cache that stores tiles on disk by z/x/y.
+// Each tile is stored next to a small JSON file with the HTTP metadata needed for freshness and revalidation.
+// It is separate from the cache of stitched maps in the stitch package.
+type diskCache struct {
+	dir string
+}
+
+// newDiskCache returns a diskCache in dir for tiles from the server url.
+// Each url gets its own sub directory, so that several tile servers can share dir.
+func newDiskCache(dir, url string) *diskCache {
+	hash := sha1.Sum([]byte(url))
+	return &diskCache{filepath.Join(dir, hex.EncodeToString(hash[:])[:10])}
+}
+
+// cacheEntry is a cached tile and its HTTP metadata
+type cacheEntry struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Expires      time.Time `json:"expires"`
+
+	data []byte
+}
+
+// newCacheEntry creates a cache entry for data based on the response headers h.
+// The bool is false if the response should not be cached at all.
+func newCacheEntry(data []byte, h http.Header, now time.Time) (*cacheEntry, bool) {
+	e := cacheEntry{
+		ETag:         h.Get("ETag"),
+		LastModified: h.Get("Last-Modified"),
+		data:         data,
+	}
+
+	var ok bool
+	e.Expires, ok = expires(h, now)
+	if !ok {
+		return nil, false
+	}
+
+	// with no validators, and nothing fresh, there is no point in keeping the tile
+	if !e.fresh(now) && e.ETag == "" && e.LastModified == "" {
+		return nil, false
+	}
+
+	return &e, true
+}
+
+// fresh reports if the entry can be used without revalidating it
+func (e *cacheEntry) fresh(now time.Time) bool {
+	return now.Before(e.Expires)
+}
+
+// revalidated updates the entry after a 304 Not Modified response with headers h
+func (e *cacheEntry) revalidated(h http.Header, now time.Time) {
+	if etag := h.Get("ETag"); etag != "" {
+		e.ETag = etag
+	}
+	if lm := h.Get("Last-Modified"); lm != "" {
+		e.LastModified = lm
+	}
+	if exp, ok := expires(h, now); ok {
+		e.Expires = exp
+	}
+}
+
+// expires calculates when a response with headers h stops being fresh.
+// It follows Cache-Control (no-store, no-cache, max-age and Age) and falls back on Expires.
+// If neither is present, a heuristic freshness of 10% of the time since Last-Modified is used.
+// The bool is false if the response must not be stored.
+func expires(h http.Header, now time.Time) (time.Time, bool) {
+	// all directives are read before any is applied, as e.g. no-store wins over a max-age before it
+	directives := map[string]string{}
+	for _, directive := range strings.Split(h.Get("Cache-Control"), ",") {
+		name, value := strings.ToLower(strings.TrimSpace(directive)), ""
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value = name[:i], name[i+1:]
+		}
+		if _, ok := directives[name]; !ok {
+			directives[name] = value
+		}
+	}
+
+	if _, ok := directives["no-store"]; ok {
+		return time.Time{}, false
+	}
+	if _, ok := directives["no-cache"]; ok {
+		return now, true
+	}
+	if value, ok := directives["max-age"]; ok {
+		maxAge, err := strconv.Atoi(value)
+		if err != nil {
+			return now, true
+		}
+		age, _ := strconv.Atoi(h.Get("Age"))
+		return now.Add(time.Duration(maxAge-age) * time.Second), true
+	}
+
+	if exp := h.Get("Expires"); exp != "" {
+		// invalid dates, like "0", means that the response is already expired
+		t, err := http.ParseTime(exp)
+		if err != nil {
+			return now, true
+		}
+		return t, true
+	}
+
+	if lm, err := http.ParseTime(h.Get("Last-Modified")); err == nil && lm.Before(now) {
+		return now.Add(now.Sub(lm) / 10), true
+	}
+
+	return now, true
+}
+
+// path returns the path of a tile in the cache. The metadata is stored in path + ".json".
+func (c *diskCache) path(z, x, y int) string {
+	return filepath.Join(c.dir, strconv.Itoa(z), strconv.Itoa(x), strconv.Itoa(y))
+}
+
+// get returns the cached entry for a tile
+func (c *diskCache) get(z, x, y int) (*cacheEntry, error) {
+	path := c.path(z, x, y)
+
+	meta, err := ioutil.ReadFile(path + ".json")
+	if err != nil {
+		return nil, err
+	}
+
+	e := cacheEntry{}
+	err = json.Unmarshal(meta, &e)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse cache metadata for tile %d/%d/%d", z, x, y)
+	}
+
+	e.data, err = ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+// remove removes a tile from the cache
+func (c *diskCache) remove(z, x, y int) error {
+	path := c.path(z, x, y)
+
+	// the metadata goes first, as a tile without it is a cache miss
+	err := os.Remove(path + ".json")
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "could not remove tile %d/%d/%d from the cache", z, x, y)
+	}
+
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "could not remove tile %d/%d/%d from the cache", z, x, y)
+	}
+
+	return nil
+}
+
+// put stores a tile in the cache.
+// Files are written to a temporary file and renamed, so that concurrent readers never see partial tiles.
+func (c *diskCache) put(z, x, y int, e *cacheEntry) error {
+	path := c.path(z, x, y)
+
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return errors.Wrapf(err, "could not create cache dir for tile %d/%d/%d", z, x, y)
+	}
+
+	meta, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrapf(err, "could not encode cache metadata for tile %d/%d/%d", z, x, y)
+	}
+
+	err = writeFile(path, e.data)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(path+".json", meta)
+}
+
+// writeFile atomically writes data to path
+func writeFile(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-"+filepath.Base(path))
+	if err != nil {
+		return errors.Wrapf(err, "could not create temp file for %s", path)
+	}
+
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return errors.Wrapf(err, "could not write %s", path)
+	}
+
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		os.Remove(f.Name())
+		return errors.Wrapf(err, "could not rename temp file to %s", path)
+	}
+
+	return nil
+}
@@ -0,0 +1,195 @@
+package tile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpires(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var expiresTest = []struct {
+		name     string
+		header   map[string]string
+		expected time.Time
+		ok       bool
+	}{
+		{"no-store", map[string]string{"Cache-Control": "no-store"}, time.Time{}, false},
+		{"no-cache", map[string]string{"Cache-Control": "no-cache"}, now, true},
+		{"max-age", map[string]string{"Cache-Control": "public, max-age=3600"}, now.Add(time.Hour), true},
+		{"max-age with age", map[string]string{"Cache-Control": "max-age=3600", "Age": "600"}, now.Add(50 * time.Minute), true},
+		{"max-age and no-store", map[string]string{"Cache-Control": "max-age=3600, no-store"}, time.Time{}, false},
+		{"max-age and no-cache", map[string]string{"Cache-Control": "max-age=3600, no-cache"}, now, true},
+		{"no-cache and max-age", map[string]string{"Cache-Control": "no-cache, max-age=3600"}, now, true},
+		{"max-age before expires", map[string]string{"Cache-Control": "max-age=60", "Expires": "Thu, 02 Jan 2020 12:00:00 GMT"}, now.Add(time.Minute), true},
+		{"expires", map[string]string{"Expires": "Thu, 02 Jan 2020 12:00:00 GMT"}, now.Add(24 * time.Hour), true},
+		{"invalid expires", map[string]string{"Expires": "0"}, now, true},
+		{"last-modified", map[string]string{"Last-Modified": "Sun, 22 Dec 2019 12:00:00 GMT"}, now.Add(24 * time.Hour), true},
+		{"nothing", map[string]string{}, now, true},
+	}
+
+	for _, test := range expiresTest {
+		t.Run(test.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range test.header {
+				h.Set(k, v)
+			}
+
+			got, ok := expires(h, now)
+			if !got.Equal(test.expected) || ok != test.ok {
+				t.Errorf("got %s,%v - want %s,%v", got, ok, test.expected, test.ok)
+			}
+		})
+	}
+}
+
+func TestServerCache(t *testing.T) {
+	buf := bytes.Buffer{}
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 256, 256)))
+	tileData := buf.Bytes()
+
+	var cacheTest = []struct {
+		name         string
+		cacheControl string
+		requests     int
+		revalidated  int
+	}{
+		{"fresh", "max-age=3600", 1, 0},
+		{"stale", "max-age=0", 2, 1},
+	}
+
+	for _, test := range cacheTest {
+		t.Run(test.name, func(t *testing.T) {
+			requests := 0
+			revalidated := 0
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.Header().Set("ETag", `"abc"`)
+				w.Header().Set("Cache-Control", test.cacheControl)
+				if r.Header.Get("If-None-Match") == `"abc"` {
+					revalidated++
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				w.Write(tileData)
+			}))
+			defer ts.Close()
+
+			dir, err := ioutil.TempDir("", "slipee")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			s, err := NewServer(ts.URL+"/{z}/{x}/{y}.png", WithCache(dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 2; i++ {
+				_, err = s.Get(context.Background(), 1, 0, 1)
+				if err != nil {
+					t.Fatalf("got error %s", err)
+				}
+			}
+
+			if requests != test.requests || revalidated != test.revalidated {
+				t.Errorf("got %d requests and %d revalidations - want %d and %d", requests, revalidated, test.requests, test.revalidated)
+			}
+		})
+	}
+}
+
+func TestServerCacheStale(t *testing.T) {
+	buf := bytes.Buffer{}
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 256, 256)))
+	tileData := buf.Bytes()
+
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		w.Header().Set("ETag", `"abc"`)
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Write(tileData)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "slipee")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServer(ts.URL+"/{z}/{x}/{y}.png", WithCache(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the stale tile is served while the server is down, but not after the server has said that it is gone
+	var staleTest = []struct {
+		status   int
+		ok       bool
+		notFound bool
+	}{
+		{http.StatusOK, true, false},
+		{http.StatusInternalServerError, true, false},
+		{http.StatusNotFound, false, true},
+		{http.StatusInternalServerError, false, false},
+	}
+
+	for _, test := range staleTest {
+		status = test.status
+		_, err = s.Get(context.Background(), 1, 0, 1)
+		if (err == nil) != test.ok || errors.Is(err, ErrNotFound) != test.notFound {
+			t.Errorf("got error '%v' with status %d - want ok %t and not found %t", err, test.status, test.ok, test.notFound)
+		}
+	}
+}
+
+func TestServerCacheRetina(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size := 256
+		if strings.Contains(r.URL.Path, "@2x") {
+			size = 512
+		}
+		w.Header().Set("Cache-Control", "max-age=3600")
+		png.Encode(w, image.NewRGBA(image.Rect(0, 0, size, size)))
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "slipee")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewServer(ts.URL+"/{z}/{x}/{y}{r}.png", WithCache(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the normal tile is cached first, and must not be taken for the retina tile, or the other way around
+	for _, scale := range []int{1, 2, 1, 2} {
+		img, err := s.Scale(scale).Get(context.Background(), 1, 0, 1)
+		if err != nil {
+			t.Fatalf("got error %s", err)
+		}
+		if got, want := img.Bounds().Dx(), 256*scale; got != want {
+			t.Errorf("got a %dpx tile at scale %d - want %dpx", got, scale, want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package tile
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// newHTTPClient returns the HTTP client for a tile source, that is shared by all its requests and mirrors.
+// Connections are kept alive between requests, up to the concurrency limit per host.
+func newHTTPClient(o options) (*http.Client, error) {
+	proxy := http.ProxyFromEnvironment
+	if o.proxy != "" {
+		u, err := url.Parse(o.proxy)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy url %s", o.proxy)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	tlsConfig, err := newTLSConfig(o)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   o.connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   o.connectTimeout,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   o.concurrency,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   o.readTimeout,
+	}, nil
+}
+
+// newTLSConfig returns the TLS config with the client certificate and CA bundle of o, or nil if there are none
+func newTLSConfig(o options) (*tls.Config, error) {
+	if o.certFile == "" && o.caFile == "" {
+		return nil, nil
+	}
+
+	c := &tls.Config{}
+
+	if o.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not load client certificate")
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+
+	if o.caFile != "" {
+		pem, err := ioutil.ReadFile(o.caFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read CA bundle")
+		}
+
+		// the CAs are trusted in addition to the ones of the system
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", o.caFile)
+		}
+		c.RootCAs = pool
+	}
+
+	return c, nil
+}
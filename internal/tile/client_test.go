@@ -0,0 +1,127 @@
+package tile
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// pngHandler responds with an empty 256x256 tile
+func pngHandler(w http.ResponseWriter, r *http.Request) {
+	png.Encode(w, image.NewRGBA(image.Rect(0, 0, 256, 256)))
+}
+
+func TestClientProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		pngHandler(w, r)
+	}))
+	defer proxy.Close()
+
+	src, err := NewServer("http://tiles.example.invalid/{z}/{x}/{y}.png", WithProxy(proxy.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = src.Get(context.Background(), 1, 1, 0)
+	if err != nil {
+		t.Fatalf("got error %s", err)
+	}
+	if proxied != "http://tiles.example.invalid/1/1/0.png" {
+		t.Errorf("got %s through the proxy - want the tile url", proxied)
+	}
+
+	_, err = NewServer("http://tiles.example.invalid/{z}/{x}/{y}.png", WithProxy("::"))
+	if err == nil {
+		t.Errorf("got no error for an invalid proxy url")
+	}
+}
+
+func TestClientReadTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		pngHandler(w, r)
+	}))
+	defer ts.Close()
+
+	src, err := NewServer(ts.URL+"/{z}/{x}/{y}.png", WithReadTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = src.Get(context.Background(), 1, 1, 0)
+	if err == nil {
+		t.Errorf("got no error for a slow server")
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			http.Error(w, "no client certificate", http.StatusForbidden)
+			return
+		}
+		pngHandler(w, r)
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "slipee")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the certificate of the test server is used both as CA and as client certificate
+	cert := ts.TLS.Certificates[0]
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600)
+
+	var tlsTests = []struct {
+		name string
+		opts []Option
+		ok   bool
+	}{
+		{"unknown CA", []Option{WithClientCert(certFile, keyFile)}, false},
+		{"no client certificate", []Option{WithCA(certFile)}, false},
+		{"mutual TLS", []Option{WithCA(certFile), WithClientCert(certFile, keyFile)}, true},
+	}
+
+	for _, test := range tlsTests {
+		t.Run(test.name, func(t *testing.T) {
+			src, err := NewServer(ts.URL+"/{z}/{x}/{y}.png", append(test.opts, WithRateLimit(0, 1))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = src.Get(context.Background(), 1, 1, 0)
+			if (err == nil) != test.ok {
+				t.Errorf("got error %v - want ok %t", err, test.ok)
+			}
+		})
+	}
+
+	_, err = NewServer(ts.URL+"/{z}/{x}/{y}.png", WithCA(keyFile))
+	if err == nil {
+		t.Errorf("got no error for a CA bundle without certificates")
+	}
+}
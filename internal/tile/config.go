@@ -0,0 +1,172 @@
+package tile
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Config is the configuration of a tile source, as given in a sources file.
+// Fields that are not set keep the value given by the options the source is opened with.
+type Config struct {
+	// URL is the url of the source, see Open
+	URL         string `json:"url"`
+	TileSize    int    `json:"tilesize,omitempty"`
+	Subdomains  string `json:"subdomains,omitempty"`
+	Retina      bool   `json:"retina,omitempty"`
+	Concurrency int    `json:"concurrency,omitempty"`
+	WMSBBox     bool   `json:"wmsbbox,omitempty"`
+	// MaxNativeZoom is the highest zoom level the source has tiles for, see Overzoom
+	MaxNativeZoom int `json:"maxnativezoom,omitempty"`
+	// RateLimit is the maximum requests per second to the host of the source, or negative for no limit
+	RateLimit float64 `json:"ratelimit,omitempty"`
+	Burst     int     `json:"burst,omitempty"`
+	// Headers are added to the requests, see WithHeaders
+	Headers map[string]string `json:"headers,omitempty"`
+	// APIKeyEnv is the name of the environment variable with the api key for ${apikey}, so that the key is not in the config
+	APIKeyEnv string `json:"apikeyenv,omitempty"`
+	Referer   string `json:"referer,omitempty"`
+	// ConnectTimeout and ReadTimeout are durations like "10s", see WithConnectTimeout and WithReadTimeout
+	ConnectTimeout Duration `json:"connecttimeout,omitempty"`
+	ReadTimeout    Duration `json:"readtimeout,omitempty"`
+	Proxy          string   `json:"proxy,omitempty"`
+	// TLSCert and TLSKey are the client certificate files, and TLSCA is a CA bundle file, see WithClientCert and WithCA
+	TLSCert string `json:"tlscert,omitempty"`
+	TLSKey  string `json:"tlskey,omitempty"`
+	TLSCA   string `json:"tlsca,omitempty"`
+	// Grid is the grid of the tiles, if they are not in the Web Mercator grid
+	Grid *GridConfig `json:"grid,omitempty"`
+}
+
+// GridConfig is a grid in a sources file, see Grid.
+// It is either the name of a built in grid, like "EPSG:4326", or a custom grid like
+//
+//	{"crs": "EPSG:25833", "origin": [-2500000, 9045984], "resolutions": [21664, 10832, 5416], "extent": [-2500000, 3500000, 3045984, 9045984]}
+//
+// where the extent is optional.
+type GridConfig struct {
+	Name        string    `json:"-"`
+	CRS         string    `json:"crs"`
+	Origin      []float64 `json:"origin"`
+	Resolutions []float64 `json:"resolutions"`
+	Extent      []float64 `json:"extent,omitempty"`
+}
+
+// UnmarshalJSON parses either the name of a grid or a custom grid
+func (g *GridConfig) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &g.Name); err == nil {
+		return nil
+	}
+
+	// the type has the same fields, but not this method
+	type custom GridConfig
+	return json.Unmarshal(b, (*custom)(g))
+}
+
+// Grid returns the grid of the config
+func (g GridConfig) Grid() (*Grid, error) {
+	if g.Name != "" {
+		return GridByName(g.Name)
+	}
+
+	if len(g.Origin) != 2 {
+		return nil, errors.New("the origin of the grid must be [x, y]")
+	}
+
+	var extent [4]float64
+	if len(g.Extent) > 0 {
+		if len(g.Extent) != 4 {
+			return nil, errors.New("the extent of the grid must be [minX, minY, maxX, maxY]")
+		}
+		copy(extent[:], g.Extent)
+	}
+
+	return NewGrid(g.CRS, g.Origin[0], g.Origin[1], g.Resolutions, extent)
+}
+
+// Duration is a time.Duration that is given as a string like "1m30s" in JSON
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return errors.New("durations must be strings, like \"10s\"")
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// Open opens the tile source of the config. The options in opts are applied before the ones from the config.
+func (c Config) Open(opts ...Option) (TileSource, error) {
+	all := append([]Option{}, opts...)
+	all = append(all, c.options()...)
+
+	if c.Grid != nil {
+		g, err := c.Grid.Grid()
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid grid")
+		}
+		all = append(all, WithGrid(g))
+	}
+
+	return Open(c.URL, all...)
+}
+
+// options returns the options set in the config
+func (c Config) options() []Option {
+	opts := []Option{
+		WithTileSize(c.TileSize),
+		WithConcurrency(c.Concurrency),
+		WithMaxNativeZoom(c.MaxNativeZoom),
+	}
+
+	if c.Subdomains != "" {
+		opts = append(opts, WithSubdomains(c.Subdomains))
+	}
+	if c.Retina {
+		opts = append(opts, WithRetina(true))
+	}
+	if c.WMSBBox {
+		opts = append(opts, WithWMSBBox(true))
+	}
+	if c.RateLimit != 0 {
+		opts = append(opts, WithRateLimit(math.Max(c.RateLimit, 0), c.Burst))
+	}
+	if len(c.Headers) > 0 {
+		opts = append(opts, WithHeaders(c.Headers))
+	}
+	if c.APIKeyEnv != "" {
+		opts = append(opts, WithAPIKey(os.Getenv(c.APIKeyEnv)))
+	}
+	if c.Referer != "" {
+		opts = append(opts, WithReferer(c.Referer))
+	}
+	if c.ConnectTimeout != 0 {
+		opts = append(opts, WithConnectTimeout(time.Duration(c.ConnectTimeout)))
+	}
+	if c.ReadTimeout != 0 {
+		opts = append(opts, WithReadTimeout(time.Duration(c.ReadTimeout)))
+	}
+	if c.Proxy != "" {
+		opts = append(opts, WithProxy(c.Proxy))
+	}
+	if c.TLSCert != "" {
+		opts = append(opts, WithClientCert(c.TLSCert, c.TLSKey))
+	}
+	if c.TLSCA != "" {
+		opts = append(opts, WithCA(c.TLSCA))
+	}
+
+	return opts
+}
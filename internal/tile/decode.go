@@ -0,0 +1,91 @@
+package tile
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	// the image formats of tiles, registered for image.Decode
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/pkg/errors"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/webp"
+)
+
+// maxImageSide is the largest width or height of a tile, or a map from a BBoxRenderer, that is decoded.
+// It keeps broken or hostile servers from making slipee allocate gigabytes for a single image.
+const maxImageSide = 8192
+
+// decode decodes an image from data. what describes the image in errors, e.g. "tile 1/2/3".
+// The errors tell what is wrong, e.g. that the server sent an HTML error page instead of a tile.
+func decode(data []byte, what string) (image.Image, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%s is empty", what)
+	}
+
+	if isText(data) {
+		return nil, fmt.Errorf("%s is text, not an image: %s", what, snippet(data))
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err == image.ErrFormat {
+		return nil, fmt.Errorf("%s is not in a supported image format, it starts with % x", what, data[:min(len(data), 8)])
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s is a corrupt %s image", what, format)
+	}
+
+	if config.Width > maxImageSide || config.Height > maxImageSide {
+		return nil, fmt.Errorf("%s is %dx%d pixels, which is more than the %dx%d allowed", what, config.Width, config.Height, maxImageSide, maxImageSide)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s is a corrupt %s image", what, format)
+	}
+
+	return img, nil
+}
+
+// isText reports if data looks like text, e.g. an HTML error page or a WMS service exception, and not like an image
+func isText(data []byte) bool {
+	head := data[:min(len(data), 512)]
+	if len(head) < len(data) {
+		// the head can end in the middle of a character
+		for i := 0; i < utf8.UTFMax-1 && !utf8.Valid(head); i++ {
+			head = head[:len(head)-1]
+		}
+	}
+	if !utf8.Valid(head) {
+		return false
+	}
+	for _, r := range string(head) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// snippet returns the start of text data for errors, with whitespace collapsed
+func snippet(data []byte) string {
+	s := strings.Join(strings.Fields(string(data[:min(len(data), 1024)])), " ")
+	if len(s) > 200 {
+		s = s[:200] + "..."
+	}
+	return fmt.Sprintf("%q", s)
+}
+
+// min returns the smaller of a and b
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,118 @@
+package tile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/bmp"
+)
+
+func TestDecode(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 3))
+	encoded := func(encode func(*bytes.Buffer) error) []byte {
+		var b bytes.Buffer
+		if err := encode(&b); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+
+	pngData := encoded(func(b *bytes.Buffer) error { return png.Encode(b, img) })
+	gifData := encoded(func(b *bytes.Buffer) error { return gif.Encode(b, img, nil) })
+	webpData, err := ioutil.ReadFile("testdata/tile.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a gif that says it is 10000x10000, which is in the header without a checksum
+	hugeGif := append([]byte{}, gifData...)
+	copy(hugeGif[6:10], []byte{0x10, 0x27, 0x10, 0x27})
+
+	var decodeTest = []struct {
+		name string
+		data []byte
+		size image.Point
+		err  string
+	}{
+		{"png", pngData, image.Pt(4, 3), ""},
+		{"jpeg", encoded(func(b *bytes.Buffer) error { return jpeg.Encode(b, img, nil) }), image.Pt(4, 3), ""},
+		{"gif", gifData, image.Pt(4, 3), ""},
+		{"bmp", encoded(func(b *bytes.Buffer) error { return bmp.Encode(b, img) }), image.Pt(4, 3), ""},
+		{"webp", webpData, image.Pt(75, 100), ""},
+		{"empty", nil, image.Point{}, "tile 1/2/3 is empty"},
+		{"html", []byte("<html>\n<body>Forbidden</body></html>"), image.Point{}, `tile 1/2/3 is text, not an image: "<html> <body>Forbidden</body></html>"`},
+		{"unknown", []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, image.Point{}, "tile 1/2/3 is not in a supported image format, it starts with 00 01 02 03 04 05 06 07"},
+		{"truncated", pngData[:len(pngData)-20], image.Point{}, "tile 1/2/3 is a corrupt png image"},
+		{"huge", hugeGif, image.Point{}, "tile 1/2/3 is 10000x10000 pixels"},
+	}
+
+	for _, test := range decodeTest {
+		t.Run(test.name, func(t *testing.T) {
+			img, err := decode(test.data, "tile 1/2/3")
+			if test.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+					t.Fatalf("got error %v - want %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %s", err)
+			}
+			if got := img.Bounds().Size(); got != test.size {
+				t.Errorf("got size %v - want %v", got, test.size)
+			}
+		})
+	}
+}
+
+func TestServerNotImage(t *testing.T) {
+	var responseTest = []struct {
+		name        string
+		contentType string
+		body        string
+		length      string
+		notFound    bool
+		err         string
+	}{
+		{"html", "text/html; charset=utf-8", "<html><body>Rate limit exceeded</body></html>", "", false, `got text/html; charset=utf-8 instead of an image: "<html><body>Rate limit exceeded</body></html>"`},
+		{"service exception", "application/vnd.ogc.se_xml", "<ServiceExceptionReport/>", "", false, "got application/vnd.ogc.se_xml instead of an image"},
+		{"empty", "image/png", "", "", true, ""},
+		{"too big", "image/png", "x", "100000000", false, "which is more than the 33554432 allowed"},
+	}
+
+	for _, test := range responseTest {
+		t.Run(test.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				if test.length != "" {
+					w.Header().Set("Content-Length", test.length)
+				}
+				w.Write([]byte(test.body))
+			}))
+			defer ts.Close()
+
+			server, err := NewServer(ts.URL+"/{z}/{x}/{y}.png", WithRateLimit(0, 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = server.Get(context.Background(), 1, 0, 0)
+			if test.notFound != errors.Is(err, ErrNotFound) {
+				t.Errorf("got error %v - want ErrNotFound %v", err, test.notFound)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("got error %v - want %s", err, test.err)
+			}
+		})
+	}
+}
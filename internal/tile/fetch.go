@@ -0,0 +1,339 @@
+package tile
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// fetcher does the HTTP requests for the tile sources that get tiles over HTTP.
+// It takes care of the raw tile cache, the per-host concurrency and rate limits,
+// and of balancing requests between equivalent mirrors.
+type fetcher struct {
+	cache   *diskCache
+	mirrors []*mirror
+	next    uint32 // the mirror to start with for the next request, round robin
+	client  *http.Client
+	header  http.Header
+	secrets []string // removed from errors
+	retina  *fetcher // the fetcher for the retina tiles of a ${r} template, see Server.Scale
+}
+
+// mirror is one of the equivalent servers that a fetcher gets tiles from
+type mirror struct {
+	host    string
+	limit   *semaphore
+	rate    *rateLimit
+	breaker breaker
+}
+
+// newFetcher returns a fetcher for the url template, which is used to namespace the cache.
+// hosts are urls, one for each mirror, that decide which host limits to use.
+func newFetcher(template string, hosts []string, o options) (*fetcher, error) {
+	client, err := newHTTPClient(o)
+	if err != nil {
+		return nil, err
+	}
+
+	f := fetcher{
+		client:  client,
+		header:  http.Header{},
+		secrets: []string{o.apiKey},
+	}
+
+	f.header.Set("User-Agent", o.userAgent)
+	if o.referer != "" {
+		f.header.Set("Referer", o.referer)
+	}
+	for name, value := range o.headers {
+		value, err := fillAPIKey(value, o.apiKey, false)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid header %s", name)
+		}
+		f.header.Set(name, value)
+	}
+
+	for _, host := range hosts {
+		f.mirrors = append(f.mirrors, &mirror{
+			host:  hostOf(host),
+			limit: hostLimit(host, o.concurrency),
+			rate:  hostRate(host, o.rate, o.burst),
+		})
+	}
+
+	// retina tiles of a ${r} template are cached apart from the normal ones, with the template filled in
+	f.retina = &f
+	retinaTemplate := retinaVar.ReplaceAllString(template, "@2x")
+	if o.cacheDir != "" {
+		if o.retina {
+			template = retinaTemplate
+		}
+		f.cache = newDiskCache(o.cacheDir, template)
+
+		if template != retinaTemplate {
+			retina := f
+			retina.cache = newDiskCache(o.cacheDir, retinaTemplate)
+			retina.retina = &retina
+			f.retina = &retina
+		}
+	}
+
+	return &f, nil
+}
+
+// tile returns the raw data of tile z/x/y, either from the cache or from the server.
+// urls are the urls of the tile, one for each mirror.
+// Stale cache entries are revalidated using ETag/Last-Modified, and served if no server can be reached.
+// Entries for tiles that the server no longer has are removed.
+func (f *fetcher) tile(ctx context.Context, urls []string, zoom, x, y int) ([]byte, error) {
+	var cached *cacheEntry
+	if f.cache != nil {
+		// a cache miss or an unreadable entry are treated the same - the tile is fetched
+		cached, _ = f.cache.get(zoom, x, y)
+		if cached != nil && cached.fresh(time.Now()) {
+			return cached.data, nil
+		}
+	}
+
+	data, h, err := f.get(ctx, urls, cached)
+	if err == nil && data != nil {
+		// error pages can echo the request, secrets and all
+		err = redact(notImage(h, data), f.secrets...)
+	}
+	if err != nil && cached != nil {
+		if !errors.Is(err, ErrNotFound) {
+			return cached.data, nil
+		}
+		// the server has removed the tile, so the cached one must not be served when it is down
+		if rerr := f.cache.remove(zoom, x, y); rerr != nil {
+			log.Printf("could not remove tile %d/%d/%d from the cache: %s", zoom, x, y, rerr)
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not get tile %d/%d/%d", zoom, x, y)
+	}
+
+	if data == nil {
+		cached.revalidated(h, time.Now())
+		// failing to write the metadata only means the tile is revalidated again next time
+		f.cache.put(zoom, x, y, cached)
+		return cached.data, nil
+	}
+
+	if f.cache != nil {
+		if entry, ok := newCacheEntry(data, h, time.Now()); ok {
+			err = f.cache.put(zoom, x, y, entry)
+			if err != nil {
+				log.Printf("could not cache tile %d/%d/%d: %s", zoom, x, y, err)
+			}
+		}
+	}
+
+	return data, nil
+}
+
+// decode decodes data from the server, like the package decode, with secrets removed from the errors, as they can have a snippet of data in them.
+// Error pages that are not sent as text, e.g. with an image Content-Type or none, can echo the request too.
+func (f *fetcher) decode(data []byte, what string) (image.Image, error) {
+	img, err := decode(data, what)
+	return img, redact(err, f.secrets...)
+}
+
+// maxRetries is how many times a request is retried after the server responds with 429 or 503
+const maxRetries = 2
+
+// get gets the first of urls that a mirror responds to, with urls being the same resource on each mirror.
+// If cached is not nil, the request is conditional, and nil data is returned if the server responds with 304 Not Modified.
+// Secrets, like api keys, are removed from errors.
+func (f *fetcher) get(ctx context.Context, urls []string, cached *cacheEntry) ([]byte, http.Header, error) {
+	data, h, err := f.fetch(ctx, urls, cached)
+	return data, h, redact(err, f.secrets...)
+}
+
+// fetch does the work of get.
+// Requests are spread over the mirrors round robin. Mirrors that fail are tried again later, see breaker,
+// and in the meantime requests go to the other mirrors. With a single mirror, there is nothing else to try,
+// so requests always go to it.
+func (f *fetcher) fetch(ctx context.Context, urls []string, cached *cacheEntry) ([]byte, http.Header, error) {
+	if len(f.mirrors) == 1 {
+		return f.getFrom(ctx, f.mirrors[0], urls[0], cached, true)
+	}
+
+	start := int(atomic.AddUint32(&f.next, 1))
+	err := errors.New("all tile server mirrors are down")
+
+	for i := range f.mirrors {
+		n := (start + i) % len(f.mirrors)
+		m := f.mirrors[n]
+		ok, trial := m.breaker.allow(time.Now())
+		if !ok {
+			continue
+		}
+
+		// busy mirrors are not retried, as there are other mirrors to try
+		var data []byte
+		var h http.Header
+		data, h, err = f.getFrom(ctx, m, urls[n], cached, false)
+
+		if err == nil || errors.Is(err, ErrNotFound) {
+			if m.breaker.success(trial) {
+				log.Printf("tile server mirror %s is back up", m.host)
+			}
+			return data, h, err
+		}
+
+		if ctx.Err() != nil {
+			// giving up is not the fault of the mirror
+			m.breaker.abort(trial)
+			return nil, nil, err
+		}
+
+		if m.breaker.failure(time.Now(), trial) {
+			log.Printf("tile server mirror %s is down, trying again in %s: %s", m.host, breakerCooldown, redact(err, f.secrets...))
+		}
+	}
+
+	return nil, nil, err
+}
+
+// getFrom does a GET request for url to mirror m. If cached is not nil, the request is conditional.
+// If retry is set, requests are retried if the server responds with 429 Too Many Requests or 503 Service Unavailable,
+// after the break the server asks for in Retry-After. All requests to the host are held off during the break.
+func (f *fetcher) getFrom(ctx context.Context, m *mirror, url string, cached *cacheEntry, retry bool) ([]byte, http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not create request")
+	}
+
+	for name, values := range f.header {
+		req.Header[name] = values
+	}
+
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	for retries := 0; ; retries++ {
+		data, h, err := f.do(req, m, cached != nil)
+
+		var busy *busyError
+		if !errors.As(err, &busy) {
+			return data, h, err
+		}
+
+		m.rate.holdOff(busy.until)
+		if !retry {
+			return nil, nil, err
+		}
+		if retries == maxRetries {
+			return nil, nil, errors.Wrapf(err, "gave up after %d retries", retries)
+		}
+	}
+}
+
+// maxResponseSize is the largest response that is read from a server, in bytes.
+// It is far more than tiles and maps need, but keeps broken or hostile servers from filling up the memory.
+const maxResponseSize = 32 << 20
+
+// notImage returns an error if the Content-Type in h says that data is text, and not the image it should be
+func notImage(h http.Header, data []byte) error {
+	if contentType := h.Get("Content-Type"); isTextType(contentType) {
+		return fmt.Errorf("got %s instead of an image: %s", contentType, snippet(data))
+	}
+	return nil
+}
+
+// isTextType reports if contentType is a text type, like the HTML error pages or XML service exceptions that servers answer with instead of images.
+// Other types, including missing and generic ones like application/octet-stream, are left to decode.
+func isTextType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "xml") || strings.HasSuffix(mediaType, "json")
+}
+
+// busyError is returned by do when the server responds with 429 or 503
+type busyError struct {
+	status int
+	until  time.Time
+}
+
+func (e *busyError) Error() string {
+	return fmt.Sprintf("got status code %d, retry after %s", e.status, e.until.Format(time.RFC3339))
+}
+
+// do does req, within the rate and concurrency limits of mirror m
+func (f *fetcher) do(req *http.Request, m *mirror, conditional bool) ([]byte, http.Header, error) {
+	ctx := req.Context()
+
+	err := m.rate.wait(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "gave up waiting for the rate limit")
+	}
+
+	err = m.limit.acquire(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "gave up waiting for a connection")
+	}
+	defer m.limit.release()
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	url := req.URL.String()
+
+	if res.StatusCode == http.StatusNotModified && conditional {
+		return nil, res.Header, nil
+	}
+
+	if res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusNoContent {
+		return nil, nil, errors.Wrapf(ErrNotFound, "got status code %d for %s", res.StatusCode, url)
+	}
+
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable {
+		return nil, nil, errors.Wrapf(&busyError{res.StatusCode, retryAfter(res.Header, time.Now())}, "server busy for %s", url)
+	}
+
+	if res.StatusCode != 200 {
+		return nil, nil, fmt.Errorf("got status code %d for %s", res.StatusCode, url)
+	}
+
+	if res.ContentLength > maxResponseSize {
+		return nil, nil, fmt.Errorf("got %d bytes from %s, which is more than the %d allowed", res.ContentLength, url, maxResponseSize)
+	}
+
+	// one byte more than allowed is read, to tell if the response is too big
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not read response")
+	}
+	if len(data) > maxResponseSize {
+		return nil, nil, fmt.Errorf("got more than the %d bytes allowed from %s", maxResponseSize, url)
+	}
+
+	// some servers answer missing tiles with an empty 200 response instead of 404 or 204
+	if len(data) == 0 {
+		return nil, nil, errors.Wrapf(ErrNotFound, "got an empty response for %s", url)
+	}
+
+	return data, res.Header, nil
+}
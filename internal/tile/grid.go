@@ -0,0 +1,219 @@
+package tile
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+)
+
+// Grid is how the map is split into tiles at each zoom level, like a TileMatrixSet of WMTS.
+// Most tile servers use the Web Mercator grid, which is the default, but national mapping agencies often use grids in their own CRS,
+// e.g. UTM zone 33 (EPSG:25833) for Norway.
+type Grid struct {
+	// CRS is the coordinate reference system of the grid, e.g. EPSG:25833
+	CRS string
+	// OriginX and OriginY is the top left corner of the grid, in the units of the CRS
+	OriginX, OriginY float64
+	// Resolutions are the units of the CRS per pixel of the tiles, for each zoom level
+	Resolutions []float64
+	// Extent is the area that has tiles, as minX, minY, maxX and maxY in the units of the CRS.
+	// Tiles outside it are not asked for. If it is not set, there are tiles everywhere right of and below the origin.
+	Extent [4]float64
+
+	crs crs
+	// columns is the number of tiles across at zoom level 0 for grids of the whole world, which wrap around the antimeridian.
+	// Their resolutions follow from the tile size, like on the tile servers that use them, and are not in Resolutions.
+	columns int
+}
+
+// Gridder is implemented by tile sources with tiles that are not in the Web Mercator grid
+type Gridder interface {
+	Grid() *Grid
+}
+
+// gridLevels is the number of zoom levels of grids of the whole world
+const gridLevels = 31
+
+// WebMercator returns the grid of most tile servers, in EPSG:3857 with a single tile of the whole world at zoom level 0
+func WebMercator() *Grid {
+	return &Grid{
+		CRS:     "EPSG:3857",
+		OriginX: -earthCircumference / 2,
+		OriginY: earthCircumference / 2,
+		Extent:  [4]float64{-earthCircumference / 2, -earthCircumference / 2, earthCircumference / 2, earthCircumference / 2},
+		crs:     crs{proj: webMercator{}},
+		columns: 1,
+	}
+}
+
+// WGS84 returns a grid in plain lat and long, EPSG:4326, with two tiles of half the world each at zoom level 0.
+// It is the same as the WorldCRS84Quad of WMTS.
+func WGS84() *Grid {
+	return &Grid{
+		CRS:     "EPSG:4326",
+		OriginX: -180,
+		OriginY: 90,
+		Extent:  [4]float64{-180, -90, 180, 90},
+		crs:     crs{proj: geographic{}, northFirst: true},
+		columns: 2,
+	}
+}
+
+// NewGrid returns a grid in crs, e.g. EPSG:25833, with the top left corner at originX, originY.
+// resolutions are the units of the CRS per pixel of the tiles, for each zoom level, and extent is the area that has tiles, see Grid.
+func NewGrid(crs string, originX, originY float64, resolutions []float64, extent [4]float64) (*Grid, error) {
+	code, err := parseCRS(crs)
+	if err != nil {
+		return nil, err
+	}
+	c, err := crsByCode(code)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resolutions) == 0 {
+		return nil, fmt.Errorf("grid has no resolutions")
+	}
+	for i, res := range resolutions {
+		if res <= 0 || math.IsNaN(res) || math.IsInf(res, 0) || (i > 0 && res >= resolutions[i-1]) {
+			return nil, fmt.Errorf("resolution %g of zoom level %d is not positive and smaller than the one before", res, i)
+		}
+	}
+
+	if extent != [4]float64{} && (extent[0] >= extent[2] || extent[1] >= extent[3]) {
+		return nil, fmt.Errorf("extent %v is not minX, minY, maxX, maxY", extent)
+	}
+
+	return &Grid{
+		CRS:         fmt.Sprintf("EPSG:%d", code),
+		OriginX:     originX,
+		OriginY:     originY,
+		Resolutions: append([]float64{}, resolutions...),
+		Extent:      extent,
+		crs:         c,
+	}, nil
+}
+
+// GridByName returns a grid of the whole world by name, either EPSG:3857 (or webmercator) or EPSG:4326 (or wgs84)
+func GridByName(name string) (*Grid, error) {
+	switch strings.ToLower(name) {
+	case "webmercator":
+		return WebMercator(), nil
+	case "wgs84":
+		return WGS84(), nil
+	}
+
+	code, err := parseCRS(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown grid '%s', use EPSG:3857, EPSG:4326 or a custom grid", name)
+	}
+	switch code {
+	case 3857, 900913:
+		return WebMercator(), nil
+	case 4326:
+		return WGS84(), nil
+	}
+	return nil, fmt.Errorf("there is no built in grid for %s, use a custom grid", name)
+}
+
+// gridOf returns the grid of src, which is Web Mercator unless src is a Gridder
+func gridOf(src TileSource) *Grid {
+	if gridder, ok := src.(Gridder); ok && gridder.Grid() != nil {
+		return gridder.Grid()
+	}
+	return WebMercator()
+}
+
+// equal reports if g and o split the map into the same tiles
+func (g *Grid) equal(o *Grid) bool {
+	if g.CRS != o.CRS || g.OriginX != o.OriginX || g.OriginY != o.OriginY || g.columns != o.columns || len(g.Resolutions) != len(o.Resolutions) {
+		return false
+	}
+	for i := range g.Resolutions {
+		if g.Resolutions[i] != o.Resolutions[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// project converts lat and long to the CRS of the grid
+func (g *Grid) project(lat, long float64) (float64, float64) {
+	return g.crs.proj.project(lat, long)
+}
+
+// maxLevel returns the highest zoom level of the grid
+func (g *Grid) maxLevel() int {
+	if g.columns > 0 {
+		return gridLevels - 1
+	}
+	return len(g.Resolutions) - 1
+}
+
+// levelResolution returns the units of the CRS per pixel of tiles of size px at zoom level
+func (g *Grid) levelResolution(level, size int) float64 {
+	if g.columns > 0 {
+		return (g.Extent[2] - g.Extent[0]) / float64(g.columns*size) / math.Exp2(float64(level))
+	}
+	return g.Resolutions[level]
+}
+
+// resolution returns the units of the CRS per pixel of a map at zoom, which may be fractional.
+// On grids of the whole world, zoom is like on Web Mercator maps, where zoom level 0 has a single 256px tile across for Web Mercator.
+// On other grids, zoom is the zoom level of the tiles, and zoom levels past the last one halve the resolution, like the ones before usually do.
+func (g *Grid) resolution(zoom float64) float64 {
+	if g.columns > 0 {
+		return g.levelResolution(0, 256) / math.Exp2(zoom)
+	}
+
+	last := len(g.Resolutions) - 1
+	if zoom >= float64(last) {
+		return g.Resolutions[last] / math.Exp2(zoom-float64(last))
+	}
+
+	// between zoom levels, the resolution changes by the same factor for each step
+	z := math.Floor(zoom)
+	lower, upper := g.Resolutions[int(z)], g.Resolutions[int(z)+1]
+	return lower * math.Pow(upper/lower, zoom-z)
+}
+
+// level returns the zoom level of the tiles to use for a map with resolution res, given tiles of size px, up to max.
+// That is the highest zoom level with tiles that are not more detailed than the map, or the lowest zoom level if all of them are.
+func (g *Grid) level(res float64, size, max int) int {
+	if m := g.maxLevel(); max > m {
+		max = m
+	}
+
+	level := 0
+	for l := 0; l <= max; l++ {
+		// allow for rounding, as the resolutions are often the same
+		if g.levelResolution(l, size) < res*(1-1e-9) {
+			break
+		}
+		level = l
+	}
+	return level
+}
+
+// tiles returns the range of tiles that are in the extent of the grid at zoom level, given tiles of size px.
+// Empty ranges have no limits.
+func (g *Grid) tiles(level, size int) image.Rectangle {
+	if g.Extent == [4]float64{} {
+		return image.Rectangle{}
+	}
+
+	span := g.levelResolution(level, size) * float64(size)
+	// allow for rounding, as extents are often on the edges of the tiles
+	return image.Rect(
+		int(math.Floor((g.Extent[0]-g.OriginX)/span+1e-9)),
+		int(math.Floor((g.OriginY-g.Extent[3])/span+1e-9)),
+		int(math.Ceil((g.Extent[2]-g.OriginX)/span-1e-9)),
+		int(math.Ceil((g.OriginY-g.Extent[1])/span-1e-9)),
+	)
+}
+
+// pixel returns the pixel of a map with resolution res that x, y in the CRS of the grid is in, counting from the origin
+func (g *Grid) pixel(x, y, res float64) (float64, float64) {
+	return (x - g.OriginX) / res, (g.OriginY - y) / res
+}
@@ -0,0 +1,207 @@
+package tile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"math"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTransverseMercator(t *testing.T) {
+	var projectTest = []struct {
+		name      string
+		proj      projection
+		lat, long float64
+		x, y      float64
+	}{
+		// the central meridian is at the false easting, with the length of the meridian arc scaled by k0
+		{"central meridian", utm(33, false, wgs84Flattening), 60, 15, 500000, 6651411.19},
+		// the zone edge on the equator is a common reference
+		{"equator", utm(33, false, wgs84Flattening), 0, 18, 833978.56, 0},
+		{"southern hemisphere", utm(33, true, wgs84Flattening), 0, 18, 833978.56, 10000000},
+		{"west of the central meridian", utm(33, false, wgs84Flattening), 0, 12, 166021.44, 0},
+	}
+
+	for _, test := range projectTest {
+		t.Run(test.name, func(t *testing.T) {
+			x, y := test.proj.project(test.lat, test.long)
+			if math.Abs(x-test.x) > 0.01 || math.Abs(y-test.y) > 0.01 {
+				t.Errorf("got %f,%f - want %f,%f", x, y, test.x, test.y)
+			}
+		})
+	}
+}
+
+func TestParseCRS(t *testing.T) {
+	var crsTest = []struct {
+		in   string
+		code int
+		ok   bool
+	}{
+		{"EPSG:25833", 25833, true},
+		{"epsg:3857", 3857, true},
+		{"urn:ogc:def:crs:EPSG::25833", 25833, true},
+		{"urn:ogc:def:crs:EPSG:6.18:3:3857", 3857, true},
+		{"urn:ogc:def:crs:EPSG:6.3:3857", 3857, true},
+		{"http://www.opengis.net/def/crs/EPSG/0/4326", 4326, true},
+		{"CRS:84", 4326, true},
+		{"urn:ogc:def:crs:OGC:1.3:CRS84", 4326, true},
+		{"EPSG:", 0, false},
+		{"25833", 0, false},
+	}
+
+	for _, test := range crsTest {
+		t.Run(test.in, func(t *testing.T) {
+			code, err := parseCRS(test.in)
+			if (err == nil) != test.ok {
+				t.Fatalf("got error %v - want ok %v", err, test.ok)
+			}
+			if code != test.code {
+				t.Errorf("got %d - want %d", code, test.code)
+			}
+		})
+	}
+}
+
+func TestGridConfig(t *testing.T) {
+	var configTest = []struct {
+		in  string
+		crs string
+		ok  bool
+	}{
+		{`"EPSG:4326"`, "EPSG:4326", true},
+		{`"webmercator"`, "EPSG:3857", true},
+		{`"EPSG:25833"`, "", false},
+		{`{"crs": "EPSG:25833", "origin": [-2500000, 9045984], "resolutions": [21664, 10832]}`, "EPSG:25833", true},
+		{`{"crs": "urn:ogc:def:crs:EPSG::3006", "origin": [-1200000, 8500000], "resolutions": [4096, 2048], "extent": [-1200000, 4305696, 2994304, 8500000]}`, "EPSG:3006", true},
+		{`{"crs": "EPSG:25833", "origin": [-2500000], "resolutions": [21664]}`, "", false},
+		{`{"crs": "EPSG:25833", "origin": [-2500000, 9045984], "resolutions": [10832, 21664]}`, "", false},
+		{`{"crs": "EPSG:25833", "origin": [-2500000, 9045984], "resolutions": []}`, "", false},
+		{`{"crs": "EPSG:2154", "origin": [0, 0], "resolutions": [1]}`, "", false},
+	}
+
+	for _, test := range configTest {
+		t.Run(test.in, func(t *testing.T) {
+			var c GridConfig
+			err := json.Unmarshal([]byte(test.in), &c)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			g, err := c.Grid()
+			if (err == nil) != test.ok {
+				t.Fatalf("got error %v - want ok %v", err, test.ok)
+			}
+			if err == nil && g.CRS != test.crs {
+				t.Errorf("got CRS %s - want %s", g.CRS, test.crs)
+			}
+		})
+	}
+}
+
+// gridSource is a mockSource in another grid
+type gridSource struct {
+	*mockSource
+	grid *Grid
+}
+
+func (g gridSource) Grid() *Grid {
+	return g.grid
+}
+
+// utm33 is the UTM zone 33 grid of the Norwegian mapping authority
+func utm33(t *testing.T) *Grid {
+	var resolutions []float64
+	for z := 0; z < 18; z++ {
+		resolutions = append(resolutions, 21664/math.Exp2(float64(z)))
+	}
+
+	g, err := NewGrid("EPSG:25833", -2500000, 9045984, resolutions, [4]float64{-2500000, 3500000, 3045984, 9045984})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestStaticMapGrid(t *testing.T) {
+	var gridTest = []struct {
+		name          string
+		grid          func(*testing.T) *Grid
+		width, height int
+		zoom          float64
+		lat, long     float64
+		// center is the tile with the center pixel, and count the number of tiles, or 0 for any number
+		center string
+		count  int
+	}{
+		{"utm", utm33, 500, 300, 10, 59.926181, 10.775909, "10/510/442", 0},
+		{"utm extent", utm33, 1000, 1000, 0, 59.926181, 10.775909, "0/0/0", 1},
+		{"utm past the last zoom level", utm33, 500, 300, 19, 59.926181, 10.775909, "17/65322/56608", 0},
+		{"wgs84", func(*testing.T) *Grid { return WGS84() }, 600, 300, 0, 10, 10, "0/1/0", 2},
+		{"wgs84 antimeridian", func(*testing.T) *Grid { return WGS84() }, 600, 300, 1, 10, 179.9, "1/3/0", 8},
+	}
+
+	for _, test := range gridTest {
+		t.Run(test.name, func(t *testing.T) {
+			src := gridSource{&mockSource{}, test.grid(t)}
+
+			img, err := StaticMap(context.Background(), src, test.width, test.height, test.zoom, 1, test.lat, test.long)
+			if err != nil {
+				t.Fatalf("got error %s", err)
+			}
+
+			if !src.requested[test.center] {
+				t.Errorf("got tiles %v requested - want %s", src.requested, test.center)
+			}
+			if test.count > 0 && len(src.requested) != test.count {
+				t.Errorf("got tiles %v requested - want %d", src.requested, test.count)
+			}
+
+			// the mock tiles have the tile numbers as colors
+			var z, x, y int
+			fmt.Sscanf(test.center, "%d/%d/%d", &z, &x, &y)
+			if got, want := img.RGBAAt(test.width/2, test.height/2), (color.RGBA{uint8(x), uint8(y), uint8(z), 255}); got != want {
+				t.Errorf("center pixel: got %v - want from tile %s", got, test.center)
+			}
+		})
+	}
+}
+
+func TestWMSGrid(t *testing.T) {
+	var gridTest = []struct {
+		version string
+		crs     string
+		bbox    string
+	}{
+		{"1.3.0", "CRS", "-90,-180,90,0"},
+		{"1.1.1", "SRS", "-180,-90,0,90"},
+	}
+
+	for _, test := range gridTest {
+		t.Run(test.version, func(t *testing.T) {
+			standIn := &wmsStandIn{}
+			ts := httptest.NewServer(standIn)
+			defer ts.Close()
+
+			src, err := NewWMS(ts.URL+"/wms?LAYERS=topo&VERSION="+test.version, WithGrid(WGS84()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = src.Get(context.Background(), 0, 0, 0)
+			if err != nil {
+				t.Fatalf("got error %s", err)
+			}
+
+			if got := standIn.requests[0][test.crs]; got != "EPSG:4326" {
+				t.Errorf("got %s %s - want EPSG:4326", test.crs, got)
+			}
+			if got := standIn.requests[0]["BBOX"]; got != test.bbox {
+				t.Errorf("got BBOX %s - want %s", got, test.bbox)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package tile
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+)
+
+// BlendMode is how a layer is blended with the layers below it
+// https://www.w3.org/TR/compositing-1/#blending
+type BlendMode string
+
+// Blend modes
+const (
+	Normal   BlendMode = "normal"
+	Multiply BlendMode = "multiply"
+	Screen   BlendMode = "screen"
+	Overlay  BlendMode = "overlay"
+	Darken   BlendMode = "darken"
+	Lighten  BlendMode = "lighten"
+)
+
+// blendFuncs has the blend function for each mode, taking backdrop and source color components in [0,1]
+var blendFuncs = map[BlendMode]func(cb, cs float64) float64{
+	Normal:   func(cb, cs float64) float64 { return cs },
+	Multiply: func(cb, cs float64) float64 { return cb * cs },
+	Screen:   func(cb, cs float64) float64 { return cb + cs - cb*cs },
+	Overlay: func(cb, cs float64) float64 {
+		// overlay is hard-light with the layers swapped
+		if cb <= 0.5 {
+			return 2 * cb * cs
+		}
+		return 1 - 2*(1-cb)*(1-cs)
+	},
+	Darken:  func(cb, cs float64) float64 { return math.Min(cb, cs) },
+	Lighten: func(cb, cs float64) float64 { return math.Max(cb, cs) },
+}
+
+// Layer is a tile source in a stack of layers
+type Layer struct {
+	// Name identifies the source, e.g. in cache keys
+	Name   string
+	Source TileSource
+	// Opacity is in [0,1]
+	Opacity float64
+	Blend   BlendMode
+}
+
+// Composite is a tile source that stacks several layers, e.g. a satellite base with a transparent road overlay on top.
+// The first layer is the bottom one. Layers that do not have a tile are left out, and ErrNotFound is only returned if no layer has the tile.
+type Composite struct {
+	layers []Layer
+}
+
+// Composite must implement TileSource, TileSizer, Scaler, Gridder and Attributor
+var _ TileSource = (*Composite)(nil)
+var _ TileSizer = (*Composite)(nil)
+var _ Scaler = (*Composite)(nil)
+var _ Gridder = (*Composite)(nil)
+var _ Attributor = (*Composite)(nil)
+
+// NewComposite returns a tile source that stacks layers, with the first layer at the bottom.
+// The layers must have the same grid, see ParseLayers.
+func NewComposite(layers ...Layer) *Composite {
+	return &Composite{layers}
+}
+
+// TileSize returns the biggest tile size of the layers. Tiles of the other layers are resized to it.
+func (c *Composite) TileSize() int {
+	size := 0
+	for _, l := range c.layers {
+		if s := tileSize(l.Source); s > size {
+			size = s
+		}
+	}
+	return size
+}
+
+// Grid returns the grid of the bottom layer, which is the grid of all layers
+func (c *Composite) Grid() *Grid {
+	if len(c.layers) == 0 {
+		return nil
+	}
+	return gridOf(c.layers[0].Source)
+}
+
+// Attribution returns the attributions of the layers, without duplicates
+func (c *Composite) Attribution() string {
+	var attributions []string
+	for _, l := range c.layers {
+		a := Attribution(l.Source)
+		if a != "" && !contains(attributions, a) {
+			attributions = append(attributions, a)
+		}
+	}
+	return strings.Join(attributions, ", ")
+}
+
+// Scale returns a Composite where the layers that are Scalers are scaled
+func (c *Composite) Scale(scale int) TileSource {
+	layers := make([]Layer, len(c.layers))
+	for i, l := range c.layers {
+		layers[i] = l
+		if scaler, ok := l.Source.(Scaler); ok {
+			layers[i].Source = scaler.Scale(scale)
+		}
+	}
+	return &Composite{layers}
+}
+
+// Get returns the tile with all layers blended together
+func (c *Composite) Get(ctx context.Context, zoom, x, y int) (image.Image, error) {
+	tiles := make([]image.Image, len(c.layers))
+	errs := make([]error, len(c.layers))
+
+	wg := sync.WaitGroup{}
+	for i, l := range c.layers {
+		wg.Add(1)
+		go func(i int, l Layer) {
+			defer wg.Done()
+			tiles[i], errs[i] = l.Source.Get(ctx, zoom, x, y)
+		}(i, l)
+	}
+	wg.Wait()
+
+	size := c.TileSize()
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	found := false
+
+	for i, l := range c.layers {
+		if errors.Is(errs[i], ErrNotFound) {
+			continue
+		}
+		if errs[i] != nil {
+			return nil, errors.Wrapf(errs[i], "could not get layer %s", l.Name)
+		}
+
+		src := tiles[i]
+		if src.Bounds().Size() != dst.Bounds().Size() {
+			resized := image.NewRGBA(dst.Bounds())
+			drawTile(resized, resized.Bounds(), src)
+			src = resized
+		}
+
+		blend(dst, src, l.Opacity, l.Blend)
+		found = true
+	}
+
+	if !found {
+		return nil, errors.Wrapf(ErrNotFound, "no layer has tile %d/%d/%d", zoom, x, y)
+	}
+
+	return dst, nil
+}
+
+// blend blends src onto dst with opacity, using mode. src must be the same size as dst.
+func blend(dst *image.RGBA, src image.Image, opacity float64, mode BlendMode) {
+	f, ok := blendFuncs[mode]
+	if !ok {
+		f = blendFuncs[Normal]
+	}
+
+	// the normal mode with full opacity is plain alpha compositing
+	if mode == Normal && opacity >= 1 {
+		draw.Draw(dst, dst.Bounds(), src, src.Bounds().Min, draw.Over)
+		return
+	}
+
+	b := dst.Bounds()
+	offset := src.Bounds().Min.Sub(b.Min)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			sr, sg, sb, sa := src.At(x+offset.X, y+offset.Y).RGBA()
+			if sa == 0 {
+				continue
+			}
+
+			i := dst.PixOffset(x, y)
+			d := dst.Pix[i : i+4 : i+4]
+
+			// everything below is in [0,1], with premultiplied colors
+			as := float64(sa) / 0xffff * opacity
+			ab := float64(d[3]) / 0xff
+
+			for c, sc := range []uint32{sr, sg, sb} {
+				// unpremultiplied colors for the blend function
+				cs := float64(sc) / float64(sa)
+				cb := 0.0
+				if d[3] > 0 {
+					cb = float64(d[c]) / float64(d[3])
+				}
+
+				// https://www.w3.org/TR/compositing-1/#generalformula
+				mixed := (1-ab)*cs + ab*f(cb, cs)
+				co := as*mixed + (1-as)*ab*cb
+				d[c] = uint8(math.Round(co * 0xff))
+			}
+
+			d[3] = uint8(math.Round((as + ab*(1-as)) * 0xff))
+		}
+	}
+}
+
+// ParseLayers parses a layer spec like "sat,roads:0.7:multiply" into layers, using the named sources.
+// Each layer is name[:opacity[:blend]], where opacity defaults to 1 and blend to normal. The first layer is the bottom one.
+// The sources of the layers must have the same grid, as the tiles are stacked as they are.
+func ParseLayers(spec string, sources map[string]TileSource) ([]Layer, error) {
+	var layers []Layer
+
+	for _, item := range strings.Split(spec, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) > 3 {
+			return nil, fmt.Errorf("layer '%s' has too many parts, use name[:opacity[:blend]]", item)
+		}
+
+		l := Layer{
+			Name:    parts[0],
+			Opacity: 1,
+			Blend:   Normal,
+		}
+
+		var ok bool
+		l.Source, ok = sources[l.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown source '%s'", l.Name)
+		}
+
+		if len(parts) > 1 && parts[1] != "" {
+			opacity, err := strconv.ParseFloat(parts[1], 64)
+			if err != nil || !(opacity >= 0 && opacity <= 1) {
+				return nil, fmt.Errorf("opacity '%s' of layer %s is not a number between 0 and 1", parts[1], l.Name)
+			}
+			l.Opacity = opacity
+		}
+
+		if len(parts) > 2 {
+			l.Blend = BlendMode(strings.ToLower(parts[2]))
+			if _, ok := blendFuncs[l.Blend]; !ok {
+				return nil, fmt.Errorf("unknown blend mode '%s' of layer %s", parts[2], l.Name)
+			}
+		}
+
+		if len(layers) > 0 && !gridOf(l.Source).equal(gridOf(layers[0].Source)) {
+			return nil, fmt.Errorf("layer %s is not in the same grid as layer %s", l.Name, layers[0].Name)
+		}
+
+		layers = append(layers, l)
+	}
+
+	return layers, nil
+}
@@ -0,0 +1,131 @@
+package tile
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// uniformSource is a TileSource that returns tiles of a single color, or ErrNotFound if missing is set
+type uniformSource struct {
+	c       color.Color
+	size    int
+	missing bool
+}
+
+func (u uniformSource) TileSize() int {
+	return u.size
+}
+
+func (u uniformSource) Get(ctx context.Context, z, x, y int) (image.Image, error) {
+	if u.missing {
+		return nil, ErrNotFound
+	}
+	img := image.NewRGBA(image.Rect(0, 0, u.size, u.size))
+	for i := 0; i < len(img.Pix); i += 4 {
+		r, g, b, a := u.c.RGBA()
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = uint8(r>>8), uint8(g>>8), uint8(b>>8), uint8(a>>8)
+	}
+	return img, nil
+}
+
+func TestComposite(t *testing.T) {
+	white := uniformSource{c: color.RGBA{255, 255, 255, 255}, size: 256}
+	grey := uniformSource{c: color.RGBA{128, 128, 128, 255}, size: 256}
+	red := uniformSource{c: color.RGBA{255, 0, 0, 255}, size: 512}
+	clear := uniformSource{c: color.RGBA{}, size: 256}
+	missing := uniformSource{size: 256, missing: true}
+
+	var compositeTests = []struct {
+		name   string
+		layers []Layer
+		want   color.RGBA
+		size   int
+	}{
+		{"single", []Layer{{"white", white, 1, Normal}}, color.RGBA{255, 255, 255, 255}, 256},
+		{"normal", []Layer{{"white", white, 1, Normal}, {"red", red, 1, Normal}}, color.RGBA{255, 0, 0, 255}, 512},
+		{"opacity", []Layer{{"white", white, 1, Normal}, {"red", red, 0.5, Normal}}, color.RGBA{255, 128, 128, 255}, 512},
+		{"multiply", []Layer{{"grey", grey, 1, Normal}, {"red", red, 1, Multiply}}, color.RGBA{128, 0, 0, 255}, 512},
+		{"screen", []Layer{{"grey", grey, 1, Normal}, {"red", red, 1, Screen}}, color.RGBA{255, 128, 128, 255}, 512},
+		{"darken", []Layer{{"grey", grey, 1, Normal}, {"red", red, 1, Darken}}, color.RGBA{128, 0, 0, 255}, 512},
+		{"lighten", []Layer{{"grey", grey, 1, Normal}, {"red", red, 1, Lighten}}, color.RGBA{255, 128, 128, 255}, 512},
+		{"transparent overlay", []Layer{{"grey", grey, 1, Normal}, {"clear", clear, 1, Multiply}}, color.RGBA{128, 128, 128, 255}, 256},
+		{"missing layer", []Layer{{"missing", missing, 1, Normal}, {"grey", grey, 1, Normal}}, color.RGBA{128, 128, 128, 255}, 256},
+		{"on nothing", []Layer{{"red", red, 0.5, Multiply}}, color.RGBA{128, 0, 0, 128}, 512},
+	}
+
+	for _, test := range compositeTests {
+		t.Run(test.name, func(t *testing.T) {
+			c := NewComposite(test.layers...)
+
+			img, err := c.Get(context.Background(), 1, 0, 0)
+			if err != nil {
+				t.Fatalf("got error %s", err)
+			}
+
+			if got := img.Bounds().Dx(); got != test.size {
+				t.Errorf("got size %d - want %d", got, test.size)
+			}
+
+			got := color.RGBAModel.Convert(img.At(10, 10)).(color.RGBA)
+			if !near(got, test.want) {
+				t.Errorf("got color %v - want %v", got, test.want)
+			}
+		})
+	}
+
+	_, err := NewComposite(Layer{"missing", missing, 1, Normal}).Get(context.Background(), 1, 0, 0)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v for no tiles - want ErrNotFound", err)
+	}
+}
+
+// near reports if the colors are the same, give or take rounding
+func near(a, b color.RGBA) bool {
+	d := func(x, y uint8) bool { return int(x)-int(y) <= 1 && int(y)-int(x) <= 1 }
+	return d(a.R, b.R) && d(a.G, b.G) && d(a.B, b.B) && d(a.A, b.A)
+}
+
+func TestParseLayers(t *testing.T) {
+	sources := map[string]TileSource{
+		"sat":   uniformSource{size: 256},
+		"roads": uniformSource{size: 256},
+		"wgs84": gridSource{&mockSource{}, WGS84()},
+	}
+
+	var parseTests = []struct {
+		spec string
+		want []Layer
+		err  bool
+	}{
+		{"sat", []Layer{{"sat", sources["sat"], 1, Normal}}, false},
+		{"sat,roads:0.7:multiply", []Layer{{"sat", sources["sat"], 1, Normal}, {"roads", sources["roads"], 0.7, Multiply}}, false},
+		{"sat, roads::Screen", []Layer{{"sat", sources["sat"], 1, Normal}, {"roads", sources["roads"], 1, Screen}}, false},
+		{"unknown", nil, true},
+		{"sat:2", nil, true},
+		{"sat:x", nil, true},
+		{"sat:NaN", nil, true},
+		{"sat:1:dodge", nil, true},
+		{"sat:1:normal:x", nil, true},
+		{"sat,wgs84", nil, true},
+	}
+
+	for _, test := range parseTests {
+		t.Run(test.spec, func(t *testing.T) {
+			got, err := ParseLayers(test.spec, sources)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v - want error %t", err, test.err)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d layers - want %d", len(got), len(test.want))
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("got layer %+v - want %+v", got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package tile
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRetryAfter is the longest a request waits for a server that has asked to be left alone.
+	// If the server asks for a longer break, requests fail until it is over.
+	maxRetryAfter = 30 * time.Second
+
+	// defaultRetryAfter is the break taken when a server responds with 429 or 503 without a Retry-After header
+	defaultRetryAfter = 5 * time.Second
+)
+
+// hostLimits holds a semaphore per tile server host.
+// It is global so that all Servers that use the same host share the limit.
+var hostLimits = struct {
+	sync.Mutex
+	m map[string]*semaphore
+}{m: map[string]*semaphore{}}
+
+// hostLimit returns the semaphore that limits concurrent requests to the host of rawurl to n.
+// If Servers that use the same host have different limits, the lowest applies to all of them.
+func hostLimit(rawurl string, n int) *semaphore {
+	host := hostOf(rawurl)
+
+	hostLimits.Lock()
+	defer hostLimits.Unlock()
+
+	limit, ok := hostLimits.m[host]
+	if !ok {
+		limit = &semaphore{n: n, released: make(chan struct{})}
+		hostLimits.m[host] = limit
+	}
+	limit.lower(n)
+
+	return limit
+}
+
+// hostRates holds a rate limiter per tile server host, shared like hostLimits
+var hostRates = struct {
+	sync.Mutex
+	m map[string]*rateLimit
+}{m: map[string]*rateLimit{}}
+
+// hostRate returns the rate limiter for the host of rawurl, allowing rate requests per second with bursts of burst requests.
+// A rate of 0 means no limit. If Servers that use the same host have different limits,
+// the lowest rate and the lowest burst apply to all of them.
+func hostRate(rawurl string, rate float64, burst int) *rateLimit {
+	host := hostOf(rawurl)
+
+	hostRates.Lock()
+	defer hostRates.Unlock()
+
+	limit, ok := hostRates.m[host]
+	if !ok {
+		limit = &rateLimit{
+			rate:   rate,
+			burst:  float64(burst),
+			tokens: float64(burst),
+			last:   time.Now(),
+		}
+		hostRates.m[host] = limit
+	}
+	limit.lower(rate, burst)
+
+	return limit
+}
+
+// hostOf returns the host of rawurl, or rawurl if it has none
+func hostOf(rawurl string) string {
+	if u, err := url.Parse(rawurl); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return rawurl
+}
+
+// rateLimit is a token bucket rate limiter, that can also be told to hold off all requests for a while
+type rateLimit struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second, 0 means no limit
+	burst  float64
+	tokens float64
+	last   time.Time // when tokens was last updated
+	until  time.Time // no requests before this, as asked by the server
+}
+
+// wait blocks until a request is allowed, or ctx is done.
+// It returns an error right away if the server has asked for a break longer than maxRetryAfter.
+func (l *rateLimit) wait(ctx context.Context) error {
+	l.mu.Lock()
+
+	now := time.Now()
+	if l.until.Sub(now) > maxRetryAfter {
+		until := l.until
+		l.mu.Unlock()
+		return fmt.Errorf("the server asked for a break until %s", until.Format(time.RFC3339))
+	}
+
+	start := now
+	if l.until.After(start) {
+		start = l.until
+	}
+
+	if l.rate > 0 {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+
+		// the token is taken right away, and the bucket can go negative - the request waits until it is paid back
+		l.tokens--
+		if l.tokens < 0 {
+			if t := now.Add(time.Duration(-l.tokens / l.rate * float64(time.Second))); t.After(start) {
+				start = t
+			}
+		}
+	}
+
+	l.mu.Unlock()
+
+	d := start.Sub(now)
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// give the token back, as the request was never made
+		l.mu.Lock()
+		if l.rate > 0 {
+			l.tokens++
+		}
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// lower lowers the rate and burst of l to rate and burst, if they are lower. A rate of 0 is no limit, and never lower.
+func (l *rateLimit) lower(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if rate > 0 && (l.rate == 0 || rate < l.rate) {
+		l.rate = rate
+	}
+	if float64(burst) < l.burst {
+		l.burst = float64(burst)
+		l.tokens = math.Min(l.tokens, l.burst)
+	}
+}
+
+// holdOff makes requests wait until t
+func (l *rateLimit) holdOff(t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if t.After(l.until) {
+		l.until = t
+	}
+}
+
+// semaphore limits the number of concurrent requests, to a limit that can be lowered while it is in use
+type semaphore struct {
+	mu       sync.Mutex
+	n        int           // the limit
+	used     int           // the requests in progress, which can be above n for a while after it is lowered
+	released chan struct{} // closed and replaced when a request is done
+}
+
+// acquire blocks until a request is allowed, or ctx is done
+func (s *semaphore) acquire(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		if s.used < s.n {
+			s.used++
+			s.mu.Unlock()
+			return nil
+		}
+		released := s.released
+		s.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release ends a request allowed by acquire
+func (s *semaphore) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.used--
+	close(s.released)
+	s.released = make(chan struct{})
+}
+
+// lower lowers the limit of s to n, if it is lower
+func (s *semaphore) lower(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n < s.n {
+		s.n = n
+	}
+}
+
+// retryAfter returns when to try again after a 429 or 503 response, from the Retry-After header.
+// It is either a number of seconds, or a HTTP date.
+func retryAfter(h http.Header, now time.Time) time.Time {
+	v := h.Get("Retry-After")
+
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return now.Add(time.Duration(seconds) * time.Second)
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		return t
+	}
+
+	return now.Add(defaultRetryAfter)
+}
@@ -0,0 +1,172 @@
+package tile
+
+import (
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	l := &rateLimit{rate: 20, burst: 2, tokens: 2, last: time.Now()}
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		err := l.wait(context.Background())
+		if err != nil {
+			t.Fatalf("got error %s", err)
+		}
+	}
+
+	// the burst of 2 is free, the next 2 take 50 ms each
+	if got := time.Since(start); got < 90*time.Millisecond || got > time.Second {
+		t.Errorf("got 4 requests in %s - want about 100ms", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.wait(ctx); err == nil {
+		t.Errorf("got no error when the context is done before the token is available")
+	}
+
+	l.holdOff(time.Now().Add(time.Hour))
+	if err := l.wait(context.Background()); err == nil {
+		t.Errorf("got no error when the server asked for a break longer than maxRetryAfter")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var retryAfterTests = []struct {
+		header string
+		want   time.Time
+	}{
+		{"120", now.Add(2 * time.Minute)},
+		{"Wed, 01 Jan 2020 12:00:30 GMT", now.Add(30 * time.Second)},
+		{"", now.Add(defaultRetryAfter)},
+		{"soon", now.Add(defaultRetryAfter)},
+	}
+
+	for _, test := range retryAfterTests {
+		t.Run(test.header, func(t *testing.T) {
+			h := http.Header{}
+			h.Set("Retry-After", test.header)
+
+			if got := retryAfter(h, now); !got.Equal(test.want) {
+				t.Errorf("got %s - want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestServerRetryAfter(t *testing.T) {
+	var busyTests = []struct {
+		name     string
+		busy     int
+		status   int
+		ok       bool
+		requests int
+	}{
+		{"429", 1, http.StatusTooManyRequests, true, 2},
+		{"503", 2, http.StatusServiceUnavailable, true, 3},
+		{"give up", 10, http.StatusServiceUnavailable, false, maxRetries + 1},
+	}
+
+	for _, test := range busyTests {
+		t.Run(test.name, func(t *testing.T) {
+			mu := sync.Mutex{}
+			requests := 0
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests++
+				n := requests
+				mu.Unlock()
+
+				if n <= test.busy {
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(test.status)
+					return
+				}
+				png.Encode(w, image.NewRGBA(image.Rect(0, 0, 256, 256)))
+			}))
+			defer ts.Close()
+
+			src, err := NewServer(ts.URL + "/{z}/{x}/{y}.png")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = src.Get(context.Background(), 1, 1, 1)
+			if (err == nil) != test.ok {
+				t.Errorf("got error %v - want ok %t", err, test.ok)
+			}
+
+			if requests != test.requests {
+				t.Errorf("got %d requests - want %d", requests, test.requests)
+			}
+		})
+	}
+}
+
+func TestHostLimitStricter(t *testing.T) {
+	mu := sync.Mutex{}
+	running, most := 0, 0
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		png.Encode(w, image.NewRGBA(image.Rect(0, 0, 256, 256)))
+	}))
+	defer ts.Close()
+
+	// the second source on the host has a lower limit, which must apply to both of them
+	var sources []TileSource
+	for _, n := range []int{4, 1} {
+		src, err := NewServer(ts.URL+"/{z}/{x}/{y}.png", WithConcurrency(n), WithRateLimit(0, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, src)
+	}
+
+	wg := sync.WaitGroup{}
+	for i, src := range sources {
+		for x := 0; x < 4; x++ {
+			wg.Add(1)
+			go func(src TileSource, x, y int) {
+				defer wg.Done()
+				if _, err := src.Get(context.Background(), 3, x, y); err != nil {
+					t.Error(err)
+				}
+			}(src, x, i)
+		}
+	}
+	wg.Wait()
+
+	if most != 1 {
+		t.Errorf("got %d concurrent requests - want 1", most)
+	}
+
+	l := hostRate("http://stricter.example.com", 10, 8)
+	hostRate("http://stricter.example.com", 2, 16)
+	hostRate("http://stricter.example.com", 0, 4)
+	if l.rate != 2 || l.burst != 4 {
+		t.Errorf("got rate %g and burst %g - want 2 and 4", l.rate, l.burst)
+	}
+}
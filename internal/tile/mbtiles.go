@@ -0,0 +1,256 @@
+package tile
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+
+	"github.com/krilor/slipee/internal/sqlite"
+	"github.com/pkg/errors"
+)
+
+// MBTiles is a tile source that reads raster tiles from an MBTiles archive
+// https://github.com/mapbox/mbtiles-spec
+//
+// Both the plain layout with a tiles table, and the common deduplicated layout
+// where tiles is a view over a map and an images table, are supported.
+type MBTiles struct {
+	db       *sqlite.DB
+	tileSize int
+
+	// lookup returns the tile data for a tile, where row is the flipped TMS row
+	lookup func(z, x, row int) ([]byte, bool, error)
+}
+
+// MBTiles must implement TileSource and TileSizer
+var _ TileSource = (*MBTiles)(nil)
+var _ TileSizer = (*MBTiles)(nil)
+
+// NewMBTiles opens the MBTiles archive at path
+func NewMBTiles(path string, opts ...Option) (*MBTiles, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	db, err := sqlite.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open mbtiles")
+	}
+
+	m := MBTiles{
+		db:       db,
+		tileSize: o.tileSize,
+	}
+
+	err = m.setup()
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrapf(err, "could not read mbtiles %s", path)
+	}
+
+	return &m, nil
+}
+
+// setup reads the schema and metadata of the archive, and sets up lookup
+func (m *MBTiles) setup() error {
+	schema, err := m.db.Schema()
+	if err != nil {
+		return err
+	}
+
+	tables := map[string]*table{}
+	for _, o := range schema {
+		if o.Type == "table" {
+			tables[o.Name] = &table{
+				db:      m.db,
+				obj:     o,
+				columns: o.Columns(),
+				alias:   o.RowidAlias(),
+			}
+		}
+	}
+	for _, o := range schema {
+		// partial indexes do not have all the rows
+		if t, ok := tables[o.Table]; ok && o.Type == "index" && !o.Partial() {
+			t.indexes = append(t.indexes, o)
+		}
+	}
+
+	if metadata, ok := tables["metadata"]; ok {
+		format, found, err := metadata.find([]string{"name"}, []interface{}{"format"}, "value")
+		if err != nil {
+			return err
+		}
+		if found && (format == "pbf" || format == "mvt") {
+			return fmt.Errorf("format %s is not supported, only raster tiles are", format)
+		}
+	}
+
+	xyz := []string{"zoom_level", "tile_column", "tile_row"}
+
+	if tiles, ok := tables["tiles"]; ok {
+		if !tiles.indexed(xyz) {
+			log.Printf("the tiles table has no index on %v - it is scanned for each tile", xyz)
+		}
+		m.lookup = func(z, x, row int) ([]byte, bool, error) {
+			data, found, err := tiles.find(xyz, []interface{}{int64(z), int64(x), int64(row)}, "tile_data")
+			b, _ := data.([]byte)
+			return b, found, err
+		}
+		return nil
+	}
+
+	tileMap, okMap := tables["map"]
+	images, okImages := tables["images"]
+	if okMap && okImages {
+		if !tileMap.indexed(xyz) || !images.indexed([]string{"tile_id"}) {
+			log.Printf("the map or images table is missing an index - they are scanned for each tile")
+		}
+		m.lookup = func(z, x, row int) ([]byte, bool, error) {
+			id, found, err := tileMap.find(xyz, []interface{}{int64(z), int64(x), int64(row)}, "tile_id")
+			if err != nil || !found {
+				return nil, found, err
+			}
+			data, found, err := images.find([]string{"tile_id"}, []interface{}{id}, "tile_data")
+			b, _ := data.([]byte)
+			return b, found, err
+		}
+		return nil
+	}
+
+	return errors.New("no tiles table, or map and images tables, found")
+}
+
+// TileSize returns the size of the tiles in the archive
+func (m *MBTiles) TileSize() int {
+	return m.tileSize
+}
+
+// Get returns a tile from the archive
+func (m *MBTiles) Get(ctx context.Context, zoom, x, y int) (image.Image, error) {
+	// MBTiles uses TMS rows, which are flipped compared to the y of slippy maps
+	row := (1 << uint(zoom)) - 1 - y
+
+	data, found, err := m.lookup(zoom, x, row)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read tile %d/%d/%d", zoom, x, y)
+	}
+	if !found || len(data) == 0 {
+		return nil, errors.Wrapf(ErrNotFound, "tile %d/%d/%d", zoom, x, y)
+	}
+
+	return decode(data, fmt.Sprintf("tile %d/%d/%d", zoom, x, y))
+}
+
+// Close closes the archive
+func (m *MBTiles) Close() error {
+	return m.db.Close()
+}
+
+// table is a table in a SQLite database, with its indexes
+type table struct {
+	db      *sqlite.DB
+	obj     sqlite.Object
+	columns []string
+	alias   int             // the INTEGER PRIMARY KEY column, if any
+	indexes []sqlite.Object // the indexes of the table, including those of its PRIMARY KEY and UNIQUE constraints
+}
+
+// indexed reports if the table has an index with keys as the leading columns
+func (t *table) indexed(keys []string) bool {
+	for _, index := range t.indexes {
+		if hasPrefix(index.Columns(), keys) {
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the value of column in the row where the columns in keys are equal to values.
+// An index with keys as the leading columns is used if there is one, otherwise the table is scanned.
+func (t *table) find(keys []string, values []interface{}, column string) (interface{}, bool, error) {
+	col := indexOf(t.columns, column)
+	if col < 0 {
+		return nil, false, fmt.Errorf("column %s not found in table %s", column, t.obj.Name)
+	}
+
+	for _, index := range t.indexes {
+		if !hasPrefix(index.Columns(), keys) {
+			continue
+		}
+
+		entry, found, err := t.db.LookupIndex(index, values...)
+		if err != nil || !found {
+			return nil, found, err
+		}
+
+		// the rowid of the row is the last value of index entries
+		var rowid int64
+		ok := false
+		if len(entry) > 0 {
+			rowid, ok = entry[len(entry)-1].(int64)
+		}
+		if !ok {
+			return nil, false, fmt.Errorf("index %s has an entry without a rowid", index.Name)
+		}
+
+		row, found, err := t.db.Row(t.obj.RootPage, rowid)
+		if err != nil || !found {
+			return nil, found, err
+		}
+
+		return t.value(row, rowid, col), true, nil
+	}
+
+	var result interface{}
+	found := false
+	err := t.db.Scan(t.obj.RootPage, func(rowid int64, row []interface{}) bool {
+		for i, key := range keys {
+			if sqlite.Compare(t.value(row, rowid, indexOf(t.columns, key)), values[i]) != 0 {
+				return true
+			}
+		}
+		result = t.value(row, rowid, col)
+		found = true
+		return false
+	})
+
+	return result, found, err
+}
+
+// value returns column col of a row, taking care of INTEGER PRIMARY KEY columns that are aliases for rowid
+func (t *table) value(row []interface{}, rowid int64, col int) interface{} {
+	if col == t.alias {
+		return rowid
+	}
+	if col < 0 || col >= len(row) {
+		// columns added with ALTER TABLE are missing in older rows
+		return nil
+	}
+	return row[col]
+}
+
+// indexOf returns the index of s in list, or -1 if it is not there
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// hasPrefix reports if list starts with prefix
+func hasPrefix(list, prefix []string) bool {
+	if len(list) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if list[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package tile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image/color"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testdata/plain.mbtiles, testdata/dedup.mbtiles, testdata/pk.mbtiles, testdata/blob.mbtiles and testdata/partial.mbtiles have zoom level 0-2, with the tiles 2/3/* missing.
+// Each tile is a single color of RGB(x*60, y*60, z*100), and they are created with python like this:
+//
+//	db.execute('CREATE TABLE tiles (zoom_level integer, tile_column integer, tile_row integer, tile_data blob)')
+//	db.execute('CREATE UNIQUE INDEX tile_index on tiles (zoom_level, tile_column, tile_row)')
+//
+// and for the deduplicated layout
+//
+//	db.execute('CREATE TABLE map (zoom_level INTEGER, tile_column INTEGER, tile_row INTEGER, tile_id TEXT)')
+//	db.execute('CREATE UNIQUE INDEX map_index ON map (zoom_level, tile_column, tile_row)')
+//	db.execute('CREATE TABLE images (tile_data blob, tile_id text)')
+//	db.execute('CREATE UNIQUE INDEX images_id ON images (tile_id)')
+//	db.execute('CREATE VIEW tiles AS SELECT ... FROM map JOIN images ON images.tile_id = map.tile_id')
+//
+// testdata/pk.mbtiles is the plain layout where the only index is that of the primary key
+//
+//	db.execute('CREATE TABLE tiles (zoom_level integer, tile_column integer, tile_row integer, tile_data blob, PRIMARY KEY (zoom_level, tile_column, tile_row))')
+//
+// testdata/blob.mbtiles is the deduplicated layout with BLOB tile ids, and no index on images, so that it is scanned
+//
+//	db.execute('CREATE TABLE map (zoom_level INTEGER, tile_column INTEGER, tile_row INTEGER, tile_id BLOB)')
+//	db.execute('CREATE UNIQUE INDEX map_index ON map (zoom_level, tile_column, tile_row)')
+//	db.execute('CREATE TABLE images (tile_data blob, tile_id blob)')
+//
+// testdata/partial.mbtiles is the plain layout with a partial index, which does not have zoom level 2, so that it is scanned
+//
+//	db.execute('CREATE UNIQUE INDEX tile_index ON tiles (zoom_level, tile_column, tile_row) WHERE zoom_level IN (0, 1)')
+
+func TestMBTiles(t *testing.T) {
+	for _, archive := range []string{"plain", "dedup", "pk", "blob", "partial"} {
+		t.Run(archive, func(t *testing.T) {
+			logs := bytes.Buffer{}
+			log.SetOutput(&logs)
+			src, err := NewMBTiles("testdata/" + archive + ".mbtiles")
+			log.SetOutput(os.Stderr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer src.Close()
+
+			// only blob.mbtiles and partial.mbtiles are missing an index that can be used
+			want := archive == "blob" || archive == "partial"
+			if scanned := strings.Contains(logs.String(), "scanned"); scanned != want {
+				t.Errorf("got log '%s' - want a warning about scanning %t", logs.String(), want)
+			}
+
+			for _, tile := range [][3]int{{0, 0, 0}, {1, 1, 0}, {2, 1, 3}} {
+				img, err := src.Get(context.Background(), tile[0], tile[1], tile[2])
+				if err != nil {
+					t.Fatalf("got error %s", err)
+				}
+
+				want := color.RGBA{uint8(tile[1] * 60), uint8(tile[2] * 60), uint8(tile[0] * 100), 255}
+				if got := color.RGBAModel.Convert(img.At(10, 10)); got != want {
+					t.Errorf("tile %v: got %v - want %v", tile, got, want)
+				}
+			}
+
+			_, err = src.Get(context.Background(), 2, 3, 1)
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("got error '%v' for missing tile - want ErrNotFound", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package tile
+
+import "time"
+
+// options holds the configuration shared by tile sources
+type options struct {
+	cacheDir    string
+	concurrency int
+	subdomains  []string
+	retina      bool
+	tileSize    int
+	wmsBBox     bool
+	maxZoom     int
+	rate        float64
+	burst       int
+	headers     map[string]string
+	apiKey      string
+	referer     string
+	userAgent   string
+	grid        *Grid
+
+	connectTimeout time.Duration
+	readTimeout    time.Duration
+	proxy          string
+	certFile       string
+	keyFile        string
+	caFile         string
+}
+
+// defaultOptions returns the options used unless an Option says otherwise
+func defaultOptions() options {
+	return options{
+		// https://operations.osmfoundation.org/policies/tiles/ allows at most 2 download threads
+		concurrency: 2,
+		subdomains:  []string{"a", "b", "c"},
+		tileSize:    256,
+		rate:        4,
+		burst:       8,
+		userAgent:   "Slipee/dev (+https://github.com/krilor/slipee)",
+
+		connectTimeout: 10 * time.Second,
+		readTimeout:    30 * time.Second,
+	}
+}
+
+// Option configures a tile source
+type Option func(*options)
+
+// WithCache enables the on-disk raw tile cache in dir.
+// Tiles are stored by z/x/y, and kept for as long as the upstream Cache-Control/Expires headers allow.
+func WithCache(dir string) Option {
+	return func(o *options) {
+		o.cacheDir = dir
+	}
+}
+
+// WithConcurrency sets the maximum number of concurrent requests to the tile server host.
+// Values below 1 are ignored. The limit is shared with other sources on the host, and the lowest limit applies.
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.concurrency = n
+		}
+	}
+}
+
+// WithSubdomains sets the subdomains that ${s} in the url template rotates between.
+// Subdomains are given as a comma separated list, e.g. "t0,t1,t2", or as a string of single letters, e.g. "abc" (the default).
+func WithSubdomains(subdomains string) Option {
+	return func(o *options) {
+		o.subdomains = parseSubdomains(subdomains)
+	}
+}
+
+// WithGrid sets the grid of the tiles of tile servers and WMS servers, for tiles that are not in the Web Mercator grid.
+// Archives are always in the Web Mercator grid. A nil grid is the Web Mercator grid.
+func WithGrid(g *Grid) Option {
+	return func(o *options) {
+		o.grid = g
+	}
+}
+
+// WithRetina makes ${r} in the url template expand to "@2x", for high-DPI tiles
+func WithRetina(retina bool) Option {
+	return func(o *options) {
+		o.retina = retina
+	}
+}
+
+// WithTileSize sets the size in pixels of the tiles, e.g. 512 for vector-rendered raster tiles.
+// Values below 1 are ignored.
+func WithTileSize(size int) Option {
+	return func(o *options) {
+		if size > 0 {
+			o.tileSize = size
+		}
+	}
+}
+
+// WithWMSBBox makes WMS sources render the whole map with a single GetMap request for its bounding box,
+// instead of one request per tile. This gives fewer requests, but the raw tile cache is not used.
+func WithWMSBBox(bbox bool) Option {
+	return func(o *options) {
+		o.wmsBBox = bbox
+	}
+}
+
+// WithMaxNativeZoom sets the highest zoom level the source has tiles for.
+// Tiles above it are made from the tiles at that zoom level, see Overzoom. Values below 1 are ignored.
+func WithMaxNativeZoom(zoom int) Option {
+	return func(o *options) {
+		if zoom > 0 {
+			o.maxZoom = zoom
+		}
+	}
+}
+
+// WithRateLimit sets the maximum requests per second to the tile server host, with bursts of up to burst requests.
+// A rate of 0 means no limit, and negative rates are ignored. Bursts below 1 are taken as 1.
+// The limit is shared with other sources on the host, and the lowest rate and burst apply.
+func WithRateLimit(rate float64, burst int) Option {
+	return func(o *options) {
+		if rate < 0 {
+			return
+		}
+		if burst < 1 {
+			burst = 1
+		}
+		o.rate = rate
+		o.burst = burst
+	}
+}
+
+// WithHeaders adds headers to the requests to the tile server, e.g. for authentication.
+// ${apikey} in the values is replaced with the api key, see WithAPIKey.
+// Headers with the same name as earlier ones, including User-Agent and Referer, replace them.
+func WithHeaders(headers map[string]string) Option {
+	return func(o *options) {
+		merged := map[string]string{}
+		for name, value := range o.headers {
+			merged[name] = value
+		}
+		for name, value := range headers {
+			merged[name] = value
+		}
+		o.headers = merged
+	}
+}
+
+// WithAPIKey sets the api key that replaces ${apikey} in the url and headers.
+// The key is removed from errors, so that it does not end up in logs.
+func WithAPIKey(key string) Option {
+	return func(o *options) {
+		o.apiKey = key
+	}
+}
+
+// WithReferer sets the Referer header of requests to the tile server, as some providers require it
+func WithReferer(referer string) Option {
+	return func(o *options) {
+		o.referer = referer
+	}
+}
+
+// WithUserAgent sets the User-Agent header of requests to the tile server.
+// Tile usage policies, like https://operations.osmfoundation.org/policies/tiles/, ask for one that identifies the application.
+func WithUserAgent(userAgent string) Option {
+	return func(o *options) {
+		if userAgent != "" {
+			o.userAgent = userAgent
+		}
+	}
+}
+
+// WithConnectTimeout sets how long to wait for a connection to the tile server, including the TLS handshake.
+// 0 means no timeout, and negative values are ignored.
+func WithConnectTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		if timeout >= 0 {
+			o.connectTimeout = timeout
+		}
+	}
+}
+
+// WithReadTimeout sets how long to wait for a whole response from the tile server, from the request is sent.
+// 0 means no timeout, and negative values are ignored.
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		if timeout >= 0 {
+			o.readTimeout = timeout
+		}
+	}
+}
+
+// WithProxy sets the url of the HTTP proxy for requests to the tile server.
+// If it is empty, the proxy is taken from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func WithProxy(proxy string) Option {
+	return func(o *options) {
+		o.proxy = proxy
+	}
+}
+
+// WithClientCert sets the PEM encoded certificate and key files used to authenticate to the tile server with mutual TLS
+func WithClientCert(certFile, keyFile string) Option {
+	return func(o *options) {
+		o.certFile = certFile
+		o.keyFile = keyFile
+	}
+}
+
+// WithCA sets a PEM encoded CA bundle that is trusted for the tile server, in addition to the system CAs
+func WithCA(caFile string) Option {
+	return func(o *options) {
+		o.caFile = caFile
+	}
+}
@@ -0,0 +1,110 @@
+package tile
+
+import (
+	"context"
+	"image"
+
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+)
+
+// overzoomLevels is how many zoom levels up Overzoom looks for an ancestor of a missing tile.
+// Beyond this, a tile would be scaled up more than 32 times, and is of no use.
+const overzoomLevels = 5
+
+// Overzoom is a tile source that makes up tiles that the source does not have from their nearest ancestor,
+// by scaling up the part of the ancestor that covers the tile.
+// This is useful as many tile servers stop at zoom 18 or 19, and archives often stop at an even lower zoom.
+//
+// Tiles above the max native zoom are always made from the tile at the max native zoom, without asking for them first.
+type Overzoom struct {
+	source  TileSource
+	maxZoom int
+}
+
+// Overzoom must implement TileSource, TileSizer, Scaler, Gridder and Attributor
+var _ TileSource = (*Overzoom)(nil)
+var _ TileSizer = (*Overzoom)(nil)
+var _ Scaler = (*Overzoom)(nil)
+var _ Gridder = (*Overzoom)(nil)
+var _ Attributor = (*Overzoom)(nil)
+
+// NewOverzoom returns src with fallback to ancestor tiles. maxZoom is the highest zoom level src has tiles for, or 0 if it is not known.
+func NewOverzoom(src TileSource, maxZoom int) *Overzoom {
+	return &Overzoom{src, maxZoom}
+}
+
+// TileSize returns the tile size of the source
+func (o *Overzoom) TileSize() int {
+	return tileSize(o.source)
+}
+
+// Grid returns the grid of the source. The zoom levels of the grid are taken to halve the resolution, like they usually do.
+func (o *Overzoom) Grid() *Grid {
+	return gridOf(o.source)
+}
+
+// Attribution returns the attribution of the source
+func (o *Overzoom) Attribution() string {
+	return Attribution(o.source)
+}
+
+// Scale returns an Overzoom of the scaled source, if it is a Scaler
+func (o *Overzoom) Scale(scale int) TileSource {
+	if scaler, ok := o.source.(Scaler); ok {
+		return NewOverzoom(scaler.Scale(scale), o.maxZoom)
+	}
+	return o
+}
+
+// Close closes the source, if it can be closed
+func (o *Overzoom) Close() error {
+	if closer, ok := o.source.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Get returns tile z/x/y from the source, or made up from its nearest ancestor if the source does not have it
+func (o *Overzoom) Get(ctx context.Context, zoom, x, y int) (image.Image, error) {
+	// d is the number of zoom levels up from the tile
+	d := 0
+	if o.maxZoom > 0 && zoom > o.maxZoom {
+		d = zoom - o.maxZoom
+	}
+
+	for first := d; ; d++ {
+		img, err := o.source.Get(ctx, zoom-d, x>>uint(d), y>>uint(d))
+		if err == nil {
+			return upscale(img, d, x, y), nil
+		}
+
+		if !errors.Is(err, ErrNotFound) || zoom-d == 0 || d-first == overzoomLevels {
+			return nil, err
+		}
+	}
+}
+
+// upscale returns the part of ancestor, d zoom levels up, that covers tile x/y, scaled up to the size of ancestor
+func upscale(ancestor image.Image, d, x, y int) image.Image {
+	if d == 0 {
+		return ancestor
+	}
+
+	b := ancestor.Bounds()
+	n := 1 << uint(d)
+
+	// the part is rounded to whole pixels, and is at least one pixel
+	min := image.Pt(b.Min.X+(x%n)*b.Dx()/n, b.Min.Y+(y%n)*b.Dy()/n)
+	max := image.Pt(b.Min.X+(x%n+1)*b.Dx()/n, b.Min.Y+(y%n+1)*b.Dy()/n)
+	if max.X == min.X {
+		max.X++
+	}
+	if max.Y == min.Y {
+		max.Y++
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.ApproxBiLinear.Scale(img, img.Bounds(), ancestor, image.Rectangle{min, max}, draw.Src, nil)
+	return img
+}
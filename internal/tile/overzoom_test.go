@@ -0,0 +1,110 @@
+package tile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// quadrantSource is a TileSource that has tiles up to maxZoom, where each quadrant of a tile has its own color
+type quadrantSource struct {
+	maxZoom   int
+	requested []string
+}
+
+// quadrantColors are the colors of the top left, top right, bottom left and bottom right quadrants
+var quadrantColors = []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {255, 255, 255, 255}}
+
+func (q *quadrantSource) Get(ctx context.Context, z, x, y int) (image.Image, error) {
+	q.requested = append(q.requested, fmt.Sprintf("%d/%d/%d", z, x, y))
+	if z > q.maxZoom {
+		return nil, ErrNotFound
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for py := 0; py < 256; py++ {
+		for px := 0; px < 256; px++ {
+			img.SetRGBA(px, py, quadrantColors[py/128*2+px/128])
+		}
+	}
+	return img, nil
+}
+
+func TestOverzoom(t *testing.T) {
+	var overzoomTests = []struct {
+		native    int
+		maxZoom   int
+		z, x, y   int
+		want      color.RGBA
+		requested []string
+	}{
+		{3, 0, 3, 1, 1, quadrantColors[0], []string{"3/1/1"}},
+		{3, 0, 4, 3, 2, quadrantColors[1], []string{"4/3/2", "3/1/1"}},
+		{3, 0, 5, 7, 7, quadrantColors[3], []string{"5/7/7", "4/3/3", "3/1/1"}},
+		{3, 3, 5, 7, 7, quadrantColors[3], []string{"3/1/1"}},
+		{3, 3, 4, 2, 3, quadrantColors[2], []string{"3/1/1"}},
+	}
+
+	for _, test := range overzoomTests {
+		t.Run(fmt.Sprintf("%d/%d/%d", test.z, test.x, test.y), func(t *testing.T) {
+			src := &quadrantSource{maxZoom: test.native}
+			o := NewOverzoom(src, test.maxZoom)
+
+			img, err := o.Get(context.Background(), test.z, test.x, test.y)
+			if err != nil {
+				t.Fatalf("got error %s", err)
+			}
+
+			if got := img.Bounds().Size(); got != image.Pt(256, 256) {
+				t.Errorf("got size %v - want 256x256", got)
+			}
+
+			// a made up tile is a single quadrant of the ancestor, while a native tile has all quadrants
+			points := []image.Point{{10, 10}, {128, 128}, {245, 245}}
+			if test.z <= test.native {
+				points = points[:1]
+			}
+			for _, p := range points {
+				if got := color.RGBAModel.Convert(img.At(p.X, p.Y)); got != test.want {
+					t.Errorf("got %v at %v - want %v", got, p, test.want)
+				}
+			}
+
+			if fmt.Sprint(src.requested) != fmt.Sprint(test.requested) {
+				t.Errorf("got requests %v - want %v", src.requested, test.requested)
+			}
+		})
+	}
+
+	// the search for an ancestor stops after overzoomLevels
+	src := &quadrantSource{maxZoom: 0}
+	_, err := NewOverzoom(src, 0).Get(context.Background(), 10, 0, 0)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v - want ErrNotFound", err)
+	}
+	if len(src.requested) != overzoomLevels+1 {
+		t.Errorf("got %d requests - want %d", len(src.requested), overzoomLevels+1)
+	}
+}
+
+func TestServerNotFound(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer ts.Close()
+
+	src, err := NewServer(ts.URL + "/{z}/{x}/{y}.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = src.Get(context.Background(), 19, 1, 1)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v for 404 - want ErrNotFound", err)
+	}
+}
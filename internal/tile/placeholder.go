@@ -0,0 +1,54 @@
+package tile
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Placeholder is what tiles that can not be had are drawn as in partial maps, see PartialMap
+type Placeholder string
+
+// Placeholders
+const (
+	// Hatch is grey with darker diagonal lines, so that it is clear that something is missing
+	Hatch Placeholder = "hatch"
+	// Transparent leaves the tiles out, so that whatever is behind the map shows through
+	Transparent Placeholder = "transparent"
+)
+
+// hatchLine is the color of the lines of Hatch, on background
+var hatchLine = color.RGBA{0xbb, 0xbb, 0xbb, 0xff}
+
+// ParsePlaceholder returns the placeholder named s, either hatch or transparent
+func ParsePlaceholder(s string) (Placeholder, error) {
+	p := Placeholder(strings.ToLower(strings.TrimSpace(s)))
+	switch p {
+	case Hatch, Transparent:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown placeholder '%s', use hatch or transparent", s)
+}
+
+// draw draws the placeholder into r of dst, a map with pixel density scale.
+// The lines of Hatch are placed from the corner of dst, so that they continue from one missing tile to the next.
+func (p Placeholder) draw(dst *image.RGBA, r image.Rectangle, scale int) {
+	if p == Transparent {
+		draw.Draw(dst, r, image.Transparent, image.Point{}, draw.Src)
+		return
+	}
+
+	draw.Draw(dst, r, image.NewUniform(background), image.Point{}, draw.Src)
+	spacing, width := 8*scale, 2*scale
+	r = r.Intersect(dst.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if (x+y)%spacing < width {
+				dst.SetRGBA(x, y, hatchLine)
+			}
+		}
+	}
+}
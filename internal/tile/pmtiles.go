@@ -0,0 +1,371 @@
+package tile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// PMTiles v3 compression and tile types
+// https://github.com/protomaps/PMTiles/blob/main/spec/v3/spec.md
+const (
+	pmCompressionUnknown = 0
+	pmCompressionNone    = 1
+	pmCompressionGzip    = 2
+
+	pmTypeMVT = 1
+)
+
+// pmHeaderSize is the size of the PMTiles v3 header
+const pmHeaderSize = 127
+
+// pmMaxLeaves is the number of leaf directories kept in memory
+const pmMaxLeaves = 64
+
+// pmMaxSize is the largest directory or tile that is read from an archive, in bytes, both before and after decompression.
+// Like maxResponseSize, it keeps corrupt archives from filling up the memory.
+const pmMaxSize = maxResponseSize
+
+// errPMCorrupt is returned, wrapped, for archives with offsets or lengths that are out of bounds
+var errPMCorrupt = errors.New("corrupt PMTiles archive")
+
+// pmHeader is the parts of a PMTiles v3 header that are needed to read tiles
+type pmHeader struct {
+	rootOffset          uint64
+	rootLength          uint64
+	leafOffset          uint64
+	tileOffset          uint64
+	internalCompression byte
+	tileCompression     byte
+	tileType            byte
+}
+
+// pmEntry is a directory entry. Entries with a run length of 0 point to leaf directories.
+type pmEntry struct {
+	tileID    uint64
+	offset    uint64
+	length    uint64
+	runLength uint64
+}
+
+// PMTiles is a tile source that reads raster tiles from a PMTiles v3 archive
+// https://github.com/protomaps/PMTiles
+//
+// The root directory is read when the archive is opened, and leaf directories are read as needed, using range reads.
+type PMTiles struct {
+	r        io.ReaderAt
+	size     uint64
+	closer   io.Closer
+	header   pmHeader
+	root     []pmEntry
+	tileSize int
+
+	mu     sync.Mutex
+	leaves map[uint64][]pmEntry
+}
+
+// PMTiles must implement TileSource and TileSizer
+var _ TileSource = (*PMTiles)(nil)
+var _ TileSizer = (*PMTiles)(nil)
+
+// NewPMTiles opens the PMTiles archive at path
+func NewPMTiles(path string, opts ...Option) (*PMTiles, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open pmtiles")
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "could not open pmtiles")
+	}
+
+	p, err := newPMTiles(f, info.Size(), opts...)
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "could not read pmtiles %s", path)
+	}
+	p.closer = f
+
+	return p, nil
+}
+
+// newPMTiles reads a PMTiles archive of size bytes from r
+func newPMTiles(r io.ReaderAt, size int64, opts ...Option) (*PMTiles, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	b := make([]byte, pmHeaderSize)
+	_, err := r.ReadAt(b, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read header")
+	}
+
+	if string(b[:7]) != "PMTiles" {
+		return nil, errors.New("not a PMTiles archive")
+	}
+	if b[7] != 3 {
+		return nil, fmt.Errorf("PMTiles version %d is not supported, only version 3 is", b[7])
+	}
+
+	p := PMTiles{
+		r:    r,
+		size: uint64(size),
+		header: pmHeader{
+			rootOffset:          binary.LittleEndian.Uint64(b[8:]),
+			rootLength:          binary.LittleEndian.Uint64(b[16:]),
+			leafOffset:          binary.LittleEndian.Uint64(b[40:]),
+			tileOffset:          binary.LittleEndian.Uint64(b[56:]),
+			internalCompression: b[97],
+			tileCompression:     b[98],
+			tileType:            b[99],
+		},
+		tileSize: o.tileSize,
+		leaves:   map[uint64][]pmEntry{},
+	}
+
+	if p.header.tileType == pmTypeMVT {
+		return nil, errors.New("vector tiles are not supported, only raster tiles are")
+	}
+
+	p.root, err = p.directory(p.header.rootOffset, p.header.rootLength)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read root directory")
+	}
+
+	return &p, nil
+}
+
+// TileSize returns the size of the tiles in the archive
+func (p *PMTiles) TileSize() int {
+	return p.tileSize
+}
+
+// Get returns a tile from the archive
+func (p *PMTiles) Get(ctx context.Context, zoom, x, y int) (image.Image, error) {
+	if zoom < 0 || x < 0 || y < 0 || x >= 1<<uint(zoom) || y >= 1<<uint(zoom) {
+		return nil, errors.Wrapf(ErrNotFound, "tile %d/%d/%d", zoom, x, y)
+	}
+
+	id := pmTileID(zoom, x, y)
+	entries := p.root
+
+	// the spec allows for leaf directories in leaf directories, but not deeper than this
+	for depth := 0; depth < 4; depth++ {
+		e, ok := pmFind(entries, id)
+		if !ok {
+			break
+		}
+
+		if e.runLength > 0 {
+			data, err := p.read(p.header.tileOffset+e.offset, e.length, p.header.tileCompression)
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not read tile %d/%d/%d", zoom, x, y)
+			}
+			return decode(data, fmt.Sprintf("tile %d/%d/%d", zoom, x, y))
+		}
+
+		var err error
+		entries, err = p.leaf(e.offset, e.length)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read leaf directory for tile %d/%d/%d", zoom, x, y)
+		}
+	}
+
+	return nil, errors.Wrapf(ErrNotFound, "tile %d/%d/%d", zoom, x, y)
+}
+
+// Close closes the archive
+func (p *PMTiles) Close() error {
+	if p.closer == nil {
+		return nil
+	}
+	return p.closer.Close()
+}
+
+// leaf returns the leaf directory at offset in the leaf directories section
+func (p *PMTiles) leaf(offset, length uint64) ([]pmEntry, error) {
+	p.mu.Lock()
+	entries, ok := p.leaves[offset]
+	p.mu.Unlock()
+	if ok {
+		return entries, nil
+	}
+
+	entries, err := p.directory(p.header.leafOffset+offset, length)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	if len(p.leaves) >= pmMaxLeaves {
+		// a simple way to bound memory - the directories in use are read again
+		p.leaves = map[uint64][]pmEntry{}
+	}
+	p.leaves[offset] = entries
+	p.mu.Unlock()
+
+	return entries, nil
+}
+
+// directory reads and decodes the directory at offset
+func (p *PMTiles) directory(offset, length uint64) ([]pmEntry, error) {
+	data, err := p.read(offset, length, p.header.internalCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	return pmDecodeDirectory(data)
+}
+
+// read reads length bytes at offset, and decompresses them
+func (p *PMTiles) read(offset, length uint64, compression byte) ([]byte, error) {
+	if length > pmMaxSize {
+		return nil, errors.Wrapf(errPMCorrupt, "%d bytes is more than the %d allowed", length, pmMaxSize)
+	}
+	if offset > p.size || length > p.size-offset {
+		return nil, errors.Wrapf(errPMCorrupt, "%d bytes at offset %d is outside the %d bytes of the archive", length, offset, p.size)
+	}
+
+	data := make([]byte, length)
+	_, err := p.r.ReadAt(data, int64(offset))
+	if err != nil {
+		return nil, err
+	}
+
+	switch compression {
+	case pmCompressionUnknown, pmCompressionNone:
+		return data, nil
+	case pmCompressionGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Wrap(err, "could not decompress")
+		}
+		defer zr.Close()
+
+		// one byte more than allowed is read, to tell if it is too big
+		data, err := ioutil.ReadAll(io.LimitReader(zr, pmMaxSize+1))
+		if err != nil {
+			return nil, errors.Wrap(err, "could not decompress")
+		}
+		if len(data) > pmMaxSize {
+			return nil, errors.Wrapf(errPMCorrupt, "more than the %d bytes allowed after decompression", pmMaxSize)
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("compression %d is not supported, only none and gzip are", compression)
+	}
+}
+
+// pmDecodeDirectory decodes a directory.
+// It is a number of entries followed by columns of tile id deltas, run lengths, lengths and offsets, all as varints.
+func pmDecodeDirectory(data []byte) ([]pmEntry, error) {
+	r := bytes.NewReader(data)
+
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read number of entries")
+	}
+	if n > uint64(len(data)) {
+		return nil, fmt.Errorf("directory claims to have %d entries in %d bytes", n, len(data))
+	}
+
+	entries := make([]pmEntry, n)
+
+	var id uint64
+	for i := range entries {
+		delta, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read tile id")
+		}
+		id += delta
+		entries[i].tileID = id
+	}
+
+	for i := range entries {
+		entries[i].runLength, err = binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read run length")
+		}
+	}
+
+	for i := range entries {
+		entries[i].length, err = binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read length")
+		}
+	}
+
+	for i := range entries {
+		offset, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read offset")
+		}
+
+		// 0 means that the data follows right after the previous entry, which the first entry does not have
+		if offset == 0 && i == 0 {
+			return nil, errors.Wrap(errPMCorrupt, "the first entry follows a previous entry")
+		}
+		if offset == 0 {
+			entries[i].offset = entries[i-1].offset + entries[i-1].length
+		} else {
+			entries[i].offset = offset - 1
+		}
+	}
+
+	return entries, nil
+}
+
+// pmFind returns the entry that covers tile id. That is either a tile entry with a run that includes id,
+// or the leaf directory entry with the highest tile id that is lower than or equal to id.
+func pmFind(entries []pmEntry, id uint64) (pmEntry, bool) {
+	// the first entry with a tile id above id - the one before it is the candidate
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].tileID > id }) - 1
+	if i < 0 {
+		return pmEntry{}, false
+	}
+
+	e := entries[i]
+	if e.runLength == 0 || id < e.tileID+e.runLength {
+		return e, true
+	}
+
+	return pmEntry{}, false
+}
+
+// pmTileID returns the PMTiles tile id of a tile.
+// Tile ids are positions on a Hilbert curve for each zoom level, counting from the lowest zoom level.
+func pmTileID(zoom, x, y int) uint64 {
+	var id uint64 = ((1 << uint(zoom*2)) - 1) / 3
+
+	tx, ty := uint64(x), uint64(y)
+	for s := uint64(1) << uint(zoom) >> 1; s > 0; s >>= 1 {
+		rx := tx & s
+		ry := ty & s
+		id += ((3 * rx) ^ ry) * s
+
+		// rotate
+		if ry == 0 {
+			if rx != 0 {
+				tx = s - 1 - tx
+				ty = s - 1 - ty
+			}
+			tx, ty = ty, tx
+		}
+	}
+
+	return id
+}
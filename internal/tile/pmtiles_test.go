@@ -0,0 +1,240 @@
+package tile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestPMTileID(t *testing.T) {
+	// from the PMTiles reference implementation tests
+	var idTest = []struct {
+		z, x, y  int
+		expected uint64
+	}{
+		{0, 0, 0, 0},
+		{1, 0, 0, 1},
+		{1, 0, 1, 2},
+		{1, 1, 1, 3},
+		{1, 1, 0, 4},
+		{2, 0, 0, 5},
+		{12, 3423, 1763, 19078479},
+	}
+
+	for _, test := range idTest {
+		t.Run(fmt.Sprintf("%d/%d/%d", test.z, test.x, test.y), func(t *testing.T) {
+			if got := pmTileID(test.z, test.x, test.y); got != test.expected {
+				t.Errorf("got %d - want %d", got, test.expected)
+			}
+		})
+	}
+}
+
+// pmEncodeDirectory encodes and gzips a directory
+func pmEncodeDirectory(entries []pmEntry) []byte {
+	var b []byte
+	varint := func(v uint64) {
+		buf := make([]byte, binary.MaxVarintLen64)
+		b = append(b, buf[:binary.PutUvarint(buf, v)]...)
+	}
+
+	varint(uint64(len(entries)))
+	last := uint64(0)
+	for _, e := range entries {
+		varint(e.tileID - last)
+		last = e.tileID
+	}
+	for _, e := range entries {
+		varint(e.runLength)
+	}
+	for _, e := range entries {
+		varint(e.length)
+	}
+	for _, e := range entries {
+		varint(e.offset + 1)
+	}
+
+	buf := bytes.Buffer{}
+	zw := gzip.NewWriter(&buf)
+	zw.Write(b)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// testPMTiles returns a PMTiles archive with zoom level 0-2, where the tiles are a single color of RGB(id, 0, 0).
+// Zoom level 0 and 1 are in the root directory, and zoom level 2 is in a leaf directory,
+// where the 4 tiles with id 5-8 share data using a run length. Tile id 20, 2/3/0, is missing.
+func testPMTiles(t *testing.T) []byte {
+	var tiles []byte
+	var root, leaf []pmEntry
+
+	for id := uint64(0); id < 20; id++ {
+		if id > 5 && id <= 8 {
+			continue
+		}
+
+		buf := bytes.Buffer{}
+		img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+		for i := range img.Pix {
+			if i%4 == 0 {
+				img.Pix[i] = uint8(id)
+			}
+			if i%4 == 3 {
+				img.Pix[i] = 255
+			}
+		}
+		png.Encode(&buf, img)
+
+		e := pmEntry{tileID: id, offset: uint64(len(tiles)), length: uint64(buf.Len()), runLength: 1}
+		if id == 5 {
+			e.runLength = 4
+		}
+		tiles = append(tiles, buf.Bytes()...)
+
+		if id < 5 {
+			root = append(root, e)
+		} else {
+			leaf = append(leaf, e)
+		}
+	}
+
+	leafDir := pmEncodeDirectory(leaf)
+	root = append(root, pmEntry{tileID: 5, offset: 0, length: uint64(len(leafDir))})
+	rootDir := pmEncodeDirectory(root)
+
+	header := make([]byte, pmHeaderSize)
+	copy(header, "PMTiles")
+	header[7] = 3
+	binary.LittleEndian.PutUint64(header[8:], pmHeaderSize)
+	binary.LittleEndian.PutUint64(header[16:], uint64(len(rootDir)))
+	binary.LittleEndian.PutUint64(header[40:], uint64(pmHeaderSize+len(rootDir)))
+	binary.LittleEndian.PutUint64(header[48:], uint64(len(leafDir)))
+	binary.LittleEndian.PutUint64(header[56:], uint64(pmHeaderSize+len(rootDir)+len(leafDir)))
+	binary.LittleEndian.PutUint64(header[64:], uint64(len(tiles)))
+	header[97] = pmCompressionGzip
+	header[98] = pmCompressionNone
+	header[99] = 2 // png
+
+	archive := append(header, rootDir...)
+	archive = append(archive, leafDir...)
+	return append(archive, tiles...)
+}
+
+func TestPMTiles(t *testing.T) {
+	archive := testPMTiles(t)
+	p, err := newPMTiles(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var getTest = []struct {
+		z, x, y int
+		id      uint8
+	}{
+		{0, 0, 0, 0},
+		{1, 1, 0, 4},
+		{2, 0, 0, 5},
+		{2, 1, 1, 5}, // shares data with 2/0/0 through the run length
+		{2, 3, 3, 15},
+		{2, 2, 0, 19},
+	}
+
+	for _, test := range getTest {
+		t.Run(fmt.Sprintf("%d/%d/%d", test.z, test.x, test.y), func(t *testing.T) {
+			img, err := p.Get(context.Background(), test.z, test.x, test.y)
+			if err != nil {
+				t.Fatalf("got error %s", err)
+			}
+
+			want := color.RGBA{test.id, 0, 0, 255}
+			if got := color.RGBAModel.Convert(img.At(10, 10)); got != want {
+				t.Errorf("got %v - want %v", got, want)
+			}
+		})
+	}
+
+	for _, missing := range [][3]int{{2, 3, 0}, {3, 0, 0}, {1, 2, 0}} {
+		_, err := p.Get(context.Background(), missing[0], missing[1], missing[2])
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("got error '%v' for missing tile %v - want ErrNotFound", err, missing)
+		}
+	}
+}
+
+// pmRawArchive returns an archive with only a root directory, written as the varints in dir, and tileLength bytes of tile data after it.
+// The header has rootLength as the length of the root directory, or the length of dir if it is 0.
+func pmRawArchive(rootLength uint64, compression byte, dir []byte, tileLength int) []byte {
+	if rootLength == 0 {
+		rootLength = uint64(len(dir))
+	}
+
+	header := make([]byte, pmHeaderSize)
+	copy(header, "PMTiles")
+	header[7] = 3
+	binary.LittleEndian.PutUint64(header[8:], pmHeaderSize)
+	binary.LittleEndian.PutUint64(header[16:], rootLength)
+	binary.LittleEndian.PutUint64(header[40:], uint64(pmHeaderSize+len(dir)))
+	binary.LittleEndian.PutUint64(header[56:], uint64(pmHeaderSize+len(dir)))
+	header[97] = compression
+	header[98] = pmCompressionNone
+	header[99] = 2 // png
+
+	archive := append(header, dir...)
+	return append(archive, make([]byte, tileLength)...)
+}
+
+// pmRawDirectory returns a directory with a single entry for tile 0, with the fields as varints
+func pmRawDirectory(runLength, length, offset uint64) []byte {
+	var dir []byte
+	for _, v := range []uint64{1, 0, runLength, length, offset} {
+		b := make([]byte, binary.MaxVarintLen64)
+		dir = append(dir, b[:binary.PutUvarint(b, v)]...)
+	}
+	return dir
+}
+
+func TestPMTilesCorrupt(t *testing.T) {
+	dir := pmRawDirectory(1, 10, 1)
+
+	var bomb bytes.Buffer
+	zw := gzip.NewWriter(&bomb)
+	zw.Write(make([]byte, pmMaxSize+1))
+	zw.Close()
+
+	var corruptTests = []struct {
+		name    string
+		archive []byte
+		open    bool // if the archive opens, and the error is from getting tile 0/0/0
+	}{
+		{"huge root", pmRawArchive(1<<62, pmCompressionNone, dir, 10), false},
+		{"root after the end", pmRawArchive(uint64(len(dir))+100, pmCompressionNone, dir, 10), false},
+		{"no previous entry", pmRawArchive(0, pmCompressionNone, pmRawDirectory(1, 10, 0), 10), false},
+		{"root bomb", pmRawArchive(0, pmCompressionGzip, bomb.Bytes(), 0), false},
+		{"huge tile", pmRawArchive(0, pmCompressionNone, pmRawDirectory(1, 1<<62, 1), 10), true},
+		{"tile after the end", pmRawArchive(0, pmCompressionNone, pmRawDirectory(1, 10, 1<<62), 10), true},
+		{"huge leaf", pmRawArchive(0, pmCompressionNone, pmRawDirectory(0, 1<<62, 1), 10), true},
+	}
+
+	for _, test := range corruptTests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := newPMTiles(bytes.NewReader(test.archive), int64(len(test.archive)))
+			if (err == nil) != test.open {
+				t.Fatalf("got error '%v' when opening - want opened %t", err, test.open)
+			}
+			if test.open {
+				_, err = p.Get(context.Background(), 0, 0, 0)
+			}
+
+			if !errors.Is(err, errPMCorrupt) {
+				t.Errorf("got error '%v' - want errPMCorrupt", err)
+			}
+		})
+	}
+}
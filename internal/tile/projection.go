@@ -0,0 +1,145 @@
+package tile
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// projection converts lat and long to x and y in the units of a coordinate reference system (CRS).
+// x is always east and y is always north, whatever the axis order of the CRS is.
+type projection interface {
+	project(lat, long float64) (float64, float64)
+}
+
+// webMercator is the projection of EPSG:3857, in meters
+// https://en.wikipedia.org/wiki/Web_Mercator_projection
+type webMercator struct{}
+
+func (webMercator) project(lat, long float64) (float64, float64) {
+	const r = earthCircumference / (2 * math.Pi)
+	latRadians := clampLat(lat) * math.Pi / 180
+	return r * long * math.Pi / 180, r * math.Log(math.Tan(math.Pi/4+latRadians/2))
+}
+
+// geographic is plain lat and long in degrees, like EPSG:4326
+type geographic struct{}
+
+func (geographic) project(lat, long float64) (float64, float64) {
+	return long, lat
+}
+
+// transverseMercator is a transverse mercator projection, like the UTM zones, in meters.
+// It uses the series of Krüger, which are accurate to well below a millimeter within the zones.
+// https://en.wikipedia.org/wiki/Universal_Transverse_Mercator_coordinate_system#From_latitude,_longitude_(%CF%86,_%CE%BB)_to_UTM_coordinates_(E,_N)
+type transverseMercator struct {
+	flattening float64 // of the ellipsoid
+	long0      float64 // the central meridian, in degrees
+	k0         float64 // the scale on the central meridian
+	falseEast  float64
+	falseNorth float64
+}
+
+// semiMajorAxis is the equatorial radius of the WGS84 and GRS80 ellipsoids, in meters
+const semiMajorAxis = 6378137
+
+// Flattening of the ellipsoids
+const (
+	wgs84Flattening = 1 / 298.257223563
+	grs80Flattening = 1 / 298.257222101
+)
+
+func (t transverseMercator) project(lat, long float64) (float64, float64) {
+	n := t.flattening / (2 - t.flattening)
+	a := semiMajorAxis / (1 + n) * (1 + n*n/4 + n*n*n*n/64)
+	alpha := []float64{
+		n/2 - 2*n*n/3 + 5*n*n*n/16,
+		13*n*n/48 - 3*n*n*n/5,
+		61 * n * n * n / 240,
+	}
+
+	phi := lat * math.Pi / 180
+	lambda := (long - t.long0) * math.Pi / 180
+
+	c := 2 * math.Sqrt(n) / (1 + n)
+	tau := math.Sinh(math.Atanh(math.Sin(phi)) - c*math.Atanh(c*math.Sin(phi)))
+	xi := math.Atan(tau / math.Cos(lambda))
+	eta := math.Atanh(math.Sin(lambda) / math.Sqrt(1+tau*tau))
+
+	e, north := eta, xi
+	for i, al := range alpha {
+		j := float64(2 * (i + 1))
+		e += al * math.Cos(j*xi) * math.Sinh(j*eta)
+		north += al * math.Sin(j*xi) * math.Cosh(j*eta)
+	}
+
+	return t.falseEast + t.k0*a*e, t.falseNorth + t.k0*a*north
+}
+
+// utm returns the projection of UTM zone, on the northern hemisphere unless south is set
+func utm(zone int, south bool, flattening float64) transverseMercator {
+	t := transverseMercator{
+		flattening: flattening,
+		long0:      float64(zone*6 - 183),
+		k0:         0.9996,
+		falseEast:  500000,
+	}
+	if south {
+		t.falseNorth = 10000000
+	}
+	return t
+}
+
+// crs is a coordinate reference system that a grid can be in
+type crs struct {
+	proj projection
+	// northFirst is set for CRSs that officially have north before east, which matters for e.g. the BBOX of WMS 1.3.0
+	northFirst bool
+}
+
+// crsByCode returns the CRS with EPSG code
+func crsByCode(code int) (crs, error) {
+	switch {
+	case code == 3857 || code == 900913:
+		return crs{proj: webMercator{}}, nil
+	case code == 4326:
+		return crs{proj: geographic{}, northFirst: true}, nil
+	case code >= 25828 && code <= 25838:
+		// ETRS89 / UTM, used all over Europe
+		return crs{proj: utm(code-25800, false, grs80Flattening)}, nil
+	case code >= 32601 && code <= 32660:
+		return crs{proj: utm(code-32600, false, wgs84Flattening)}, nil
+	case code >= 32701 && code <= 32760:
+		return crs{proj: utm(code-32700, true, wgs84Flattening)}, nil
+	case code == 3006:
+		// SWEREF99 TM, used in Sweden, which is UTM zone 33 with the axes the other way around
+		return crs{proj: utm(33, false, grs80Flattening), northFirst: true}, nil
+	case code == 3067:
+		// ETRS-TM35FIN, used in Finland, which is UTM zone 35
+		return crs{proj: utm(35, false, grs80Flattening)}, nil
+	}
+	return crs{}, fmt.Errorf("EPSG:%d is not a supported CRS", code)
+}
+
+// crsCode matches the EPSG code at the end of the common ways of naming a CRS
+var crsCode = regexp.MustCompile(`(?i)^(?:EPSG:|urn:ogc:def:crs:EPSG:(?:[\d.]*:)+|https?://www\.opengis\.net/def/crs/EPSG/[\d.]+/)(\d+)$`)
+
+// parseCRS returns the EPSG code of a CRS like EPSG:25833, urn:ogc:def:crs:EPSG::25833 or http://www.opengis.net/def/crs/EPSG/0/25833.
+// Versions in urns are skipped, including the odd urn:ogc:def:crs:EPSG:6.18:3:3857 of many WMTS servers.
+// CRS:84, which is EPSG:4326 with the axes the other way around, is taken as EPSG:4326, as axes are always east and north here.
+func parseCRS(s string) (int, error) {
+	s = strings.TrimSpace(s)
+
+	switch strings.ToUpper(s) {
+	case "CRS:84", "URN:OGC:DEF:CRS:OGC:1.3:CRS84", "HTTP://WWW.OPENGIS.NET/DEF/CRS/OGC/1.3/CRS84":
+		return 4326, nil
+	}
+
+	m := crsCode.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("'%s' is not an EPSG CRS", s)
+	}
+	return strconv.Atoi(m[1])
+}
@@ -0,0 +1,55 @@
+package tile
+
+import (
+	"strings"
+)
+
+// Open returns a tile source for rawurl. The scheme of the url decides what kind of source it is:
+//
+//	wms+http://, wms+https://  a WMS server, see NewWMS
+//	mbtiles://                 a MBTiles archive, e.g. mbtiles:///path/file.mbtiles, see NewMBTiles
+//	pmtiles://                 a PMTiles archive, e.g. pmtiles:///path/file.pmtiles, see NewPMTiles
+//	wmts+http://, wmts+https://, wmts+file://
+//	                           a layer of a WMTS server, set up from its GetCapabilities document, see NewWMTS
+//	http://, https://          a tile server, see NewServer
+//
+// Tiles that the source does not have are made from their nearest ancestor, see Overzoom.
+func Open(rawurl string, opts ...Option) (TileSource, error) {
+	src, err := open(rawurl, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// bbox renderers make the whole map at once, so there are no tiles to fall back from
+	if _, ok := src.(BBoxRenderer); ok {
+		return src, nil
+	}
+
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	// the capabilities of WMTS servers say which zoom levels there are
+	if w, ok := src.(*WMTS); ok && o.maxZoom == 0 {
+		o.maxZoom = w.maxZoom
+	}
+
+	return NewOverzoom(src, o.maxZoom), nil
+}
+
+// open returns the tile source for rawurl, based on its scheme
+func open(rawurl string, opts ...Option) (TileSource, error) {
+	switch {
+	case strings.HasPrefix(rawurl, "mbtiles://"):
+		return NewMBTiles(strings.TrimPrefix(rawurl, "mbtiles://"), opts...)
+	case strings.HasPrefix(rawurl, "pmtiles://"):
+		return NewPMTiles(strings.TrimPrefix(rawurl, "pmtiles://"), opts...)
+	case strings.HasPrefix(rawurl, "wmts+"):
+		return NewWMTS(strings.TrimPrefix(rawurl, "wmts+"), opts...)
+	case strings.HasPrefix(rawurl, "wms+"):
+		return NewWMS(strings.TrimPrefix(rawurl, "wms+"), opts...)
+	default:
+		return NewServer(rawurl, opts...)
+	}
+}
@@ -0,0 +1,96 @@
+package tile
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// fileScheme is the prefix of templates for tiles in a local directory
+const fileScheme = "file://"
+
+// unknownVariable matches template variables that are left after Server.setURL
+var unknownVariable = regexp.MustCompile(`\$?{[^}]*}`)
+
+// validateTemplate checks that a template created by Server.setURL can be used to get tiles
+func validateTemplate(template string) error {
+
+	if v := unknownVariable.FindString(template); v != "" {
+		return fmt.Errorf("unknown variable %s", v)
+	}
+
+	hasQuadkey := strings.Contains(template, "%[6]s")
+	hasXYZ := strings.Contains(template, "%[1]d") &&
+		strings.Contains(template, "%[2]d") &&
+		(strings.Contains(template, "%[3]d") || strings.Contains(template, "%[4]d"))
+
+	if !hasQuadkey && !hasXYZ {
+		return errors.New("either ${z}, ${x} and ${y} (or ${-y}), or ${q} must be present")
+	}
+
+	// file:// templates are paths, e.g. file:///data/tiles/{z}/{x}/{y}.png or file://tiles/{z}/{x}/{y}.png for relative paths
+	if strings.HasPrefix(template, fileScheme) {
+		if strings.TrimPrefix(template, fileScheme) == "" {
+			return errors.New("path is missing")
+		}
+		return nil
+	}
+
+	// the url is checked with all variables filled in
+	u, err := url.Parse(fmt.Sprintf(template, 0, 0, 0, 0, "a", "0", ""))
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme '%s'", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return errors.New("host is missing")
+	}
+
+	return nil
+}
+
+// quadkey returns the Bing Maps quadkey for a tile
+// https://docs.microsoft.com/en-us/bingmaps/articles/bing-maps-tile-system
+func quadkey(zoom, x, y int) string {
+	key := make([]byte, zoom)
+	for i := zoom; i > 0; i-- {
+		digit := byte('0')
+		mask := 1 << uint(i-1)
+		if x&mask != 0 {
+			digit++
+		}
+		if y&mask != 0 {
+			digit += 2
+		}
+		key[zoom-i] = digit
+	}
+	return string(key)
+}
+
+// parseSubdomains splits subdomains given as a comma separated list, e.g. "t0,t1,t2", or as a string of single letters, e.g. "abc"
+func parseSubdomains(subdomains string) []string {
+	if subdomains == "" {
+		return nil
+	}
+
+	if strings.Contains(subdomains, ",") {
+		return strings.Split(subdomains, ",")
+	}
+
+	return strings.Split(subdomains, "")
+}
+
+// abs returns the absolute value of i
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
@@ -0,0 +1,96 @@
+package tile
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNewServerValidation(t *testing.T) {
+	var validationTest = []struct {
+		in    string
+		valid bool
+	}{
+		{"https://a.tile.openstreetmap.org/${z}/${x}/${y}.png", true},
+		{"https://{s}.tile.openstreetmap.org/{z}/{x}/{y}{r}.png", true},
+		{"http://example.com/tms/{z}/{x}/{-y}.png", true},
+		{"https://ecn.t0.tiles.virtualearth.net/tiles/a{q}.jpeg?g=1", true},
+		{"https://example.com/{z}/{x}.png", false},
+		{"https://example.com/{z}/{x}/{y}/{foo}.png", false},
+		{"ftp://example.com/{z}/{x}/{y}.png", false},
+		{"/{z}/{x}/{y}.png", false},
+		{"file:///data/tiles/{z}/{x}/{y}.png", true},
+		{"file://tiles/{z}/{x}/{y}.png", true},
+		{"file:///data/tiles/{z}/{x}.png", false},
+		{"https://a.example.com/{z}/{x}/{y}.png https://b.example.com/{z}/{x}/{y}.png", true},
+		{"https://a.example.com/{z}/{x}/{y}.png https://b.example.com/{z}/{x}.png", false},
+		{"file:///a/{z}/{x}/{y}.png file:///b/{z}/{x}/{y}.png", false},
+		{"file:///my tiles/{z}/{x}/{y}.png", true},
+		{" ", false},
+	}
+
+	for _, test := range validationTest {
+		t.Run(test.in, func(t *testing.T) {
+			_, err := NewServer(test.in)
+			if (err == nil) != test.valid {
+				t.Errorf("got error '%v' - want valid %v", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestServerURL(t *testing.T) {
+	// a grid in UTM with 5 rows at zoom level 3, where Web Mercator has 8
+	var resolutions []float64
+	for z := 0; z < 18; z++ {
+		resolutions = append(resolutions, 21664/math.Exp2(float64(z)))
+	}
+	utm, err := NewGrid("EPSG:25833", -2500000, 9045984, resolutions, [4]float64{-2500000, 6000000, 3045984, 9045984})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var urlTest = []struct {
+		in       string
+		opts     []Option
+		z, x, y  int
+		expected string
+	}{
+		{"https://{s}.example.com/{z}/{x}/{y}.png", nil, 3, 4, 1, "https://c.example.com/3/4/1.png"},
+		{"https://{s}.example.com/{z}/{x}/{y}.png", []Option{WithSubdomains("t0,t1")}, 3, 4, 1, "https://t1.example.com/3/4/1.png"},
+		{"https://example.com/{z}/{x}/{-y}.png", nil, 3, 4, 1, "https://example.com/3/4/6.png"},
+		{"https://example.com/utm/{z}/{x}/{-y}.png", []Option{WithGrid(utm)}, 3, 4, 1, "https://example.com/utm/3/4/3.png"},
+		{"https://example.com/wgs84/{z}/{x}/{-y}.png", []Option{WithGrid(WGS84())}, 1, 3, 0, "https://example.com/wgs84/1/3/1.png"},
+		{"https://example.com/{q}.png", nil, 3, 3, 5, "https://example.com/213.png"},
+		{"https://example.com/{z}/{x}/{y}{r}.png", nil, 1, 0, 1, "https://example.com/1/0/1.png"},
+		{"https://example.com/{z}/{x}/{y}{r}.png", []Option{WithRetina(true)}, 1, 0, 1, "https://example.com/1/0/1@2x.png"},
+		{"https://example.com/{z}/{x}/{y}.png?style=a%20b", nil, 1, 0, 1, "https://example.com/1/0/1.png?style=a%20b"},
+	}
+
+	for _, test := range urlTest {
+		t.Run(test.expected, func(t *testing.T) {
+			s, err := NewServer(test.in, test.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := s.url(test.z, test.x, test.y); got != test.expected {
+				t.Errorf("got %s - want %s", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestNewServerFlipYGrid(t *testing.T) {
+	noExtent, err := NewGrid("EPSG:25833", -2500000, 9045984, []float64{21664, 10832}, [4]float64{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without an extent, there is no bottom row to count ${-y} from
+	if _, err := NewServer("https://example.com/{z}/{x}/{-y}.png", WithGrid(noExtent)); err == nil {
+		t.Errorf("got no error for ${-y} with a grid without an extent")
+	}
+	if _, err := NewServer("https://example.com/{z}/{x}/{y}.png", WithGrid(noExtent)); err != nil {
+		t.Errorf("got error %s for ${y} with a grid without an extent", err)
+	}
+}
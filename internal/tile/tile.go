@@ -1,109 +1,338 @@
 package tile
 
 import (
+	"context"
 	"fmt"
 	"image"
-	"image/draw"
+	"image/color"
+	"io/ioutil"
+	"os"
 
 	"math"
-	"net/http"
 	"regexp"
+	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
 )
 
 // latLimit is the upper/lower latitude limit for web mercator maps
 var latLimit float64 = (math.Atan(math.Sinh(math.Pi)) / (2.0 * math.Pi)) * 360.0
 
+// background is the color of the map where there are no tiles, i.e. beyond latLimit. It is the same as in Leaflet.
+var background = color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
+
 // find takes a lat, long and zoom and returns a tile and a image.Point for the coordinate that represents the position.
+// Latitudes beyond latLimit are clamped to it, and longitudes wrap around the antimeridian.
 func find(lat, long float64, zoom int) (int, int, image.Point) {
 
 	pp := image.Point{}
 	var x int
 	var y int
 
-	mx, my := latLongToWebMercator(lat, long)
+	mx, my := latLongToWebMercator(clampLat(lat), long)
 
 	x, pp.X = mercatorToPixel(mx, zoom)
 	y, pp.Y = mercatorToPixel(my, zoom)
 
+	n := 1 << uint(zoom)
+	x = wrap(x, n)
+	// the south pole is at the bottom edge of the last row of tiles
+	if y >= n {
+		y, pp.Y = n-1, 255
+	}
+
 	return x, y, pp
 }
 
+// clampLat clamps lat to the latitudes of web mercator maps
+func clampLat(lat float64) float64 {
+	return math.Max(-latLimit, math.Min(latLimit, lat))
+}
+
+// wrap returns tile number x wrapped around the antimeridian, to between 0 and n-1
+func wrap(x, n int) int {
+	return (x%n + n) % n
+}
+
+// ErrNotFound is returned, possibly wrapped, by tile sources that do not have the tile asked for
+var ErrNotFound = errors.New("tile not found")
+
+// TileSource is anything that can provide map tiles by zoom level and tile numbers.
+// Server implements it by fetching tiles over HTTP, but alternative backends (local files, archives, mocks, composites)
+// can be plugged into StaticMap and the stitch package by implementing this interface.
+type TileSource interface {
+	Get(ctx context.Context, z, x, y int) (image.Image, error)
+}
+
 // Server is a servever to get tiles from
 // https://wiki.openstreetmap.org/wiki/tile_servers
 type Server struct {
-	server string
+	server     string   // the url of the first mirror, as a fmt format string
+	mirrors    []string // the urls of all mirrors, as fmt format strings
+	subdomains []string
+	retina     bool
+	tileSize   int
+	local      bool // tiles are read from a directory with a file:// template
+	grid       *Grid
+	fetcher    *fetcher
 }
 
+// Server must implement TileSource, TileSizer, Scaler and Gridder
+var _ TileSource = (*Server)(nil)
+var _ TileSizer = (*Server)(nil)
+var _ Scaler = (*Server)(nil)
+var _ Gridder = (*Server)(nil)
+
 // NewServer returns a new Server for the given URL
 // url takes the format
 // https://a.tile.openstreetmap.org/${z}/${x}/${y}.png
-func NewServer(url string) *Server {
+//
+// Tiles can also be read from a local directory tree, using a file:// url, e.g.
+// file:///data/tiles/${z}/${x}/${y}.png. The path is used as is, and relative paths like file://tiles/${z}/${x}/${y}.png are allowed.
+//
+// Besides ${z}, ${x} and ${y}, the url can contain
+// ${-y} for TMS style flipped rows, ${q} for Bing style quadkeys,
+// ${s} for subdomains (see WithSubdomains), ${r} for a "@2x" retina suffix (see WithRetina)
+// and ${apikey} for the api key (see WithAPIKey).
+// An error is returned if the url is not a valid tile url template.
+//
+// The url can also be several urls of equivalent tile servers, separated by whitespace.
+// Requests are then spread over them, and servers that fail are left alone for a while, see breaker.
+// file:// urls have no mirrors, and are taken as they are, so that paths can have spaces in them.
+func NewServer(url string, opts ...Option) (*Server, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	// the api key is filled in right away, while the url with ${apikey} is used for the cache, so that it does not depend on the key
+	filled, err := fillAPIKey(url, o.apiKey, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid tile server url")
+	}
+
 	s := Server{}
-	s.setURL(url)
-	return &s
+	s.setURL(filled)
+
+	if len(s.mirrors) == 0 {
+		return nil, errors.New("tile server url is missing")
+	}
+
+	urls := splitMirrors(url)
+	for i, mirror := range s.mirrors {
+		err := validateTemplate(mirror)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid tile server url %s", urls[i])
+		}
+	}
+
+	s.subdomains = o.subdomains
+	s.retina = o.retina
+	s.tileSize = o.tileSize
+	s.grid = o.grid
+	if s.grid != nil && s.grid.Extent == [4]float64{} && s.hasFlippedY() {
+		return nil, errors.New("${-y} can not be used with a grid without an extent, as it has no bottom row")
+	}
+
+	if strings.HasPrefix(s.server, fileScheme) {
+		// the path can have spaces, but not other urls after them
+		for _, field := range strings.Fields(url)[1:] {
+			if strings.Contains(field, "://") {
+				return nil, errors.New("file:// urls can not have mirrors")
+			}
+		}
+		s.local = true
+	} else {
+		for _, mirror := range s.mirrors {
+			if strings.HasPrefix(mirror, fileScheme) {
+				return nil, errors.New("file:// urls can not be mirrors")
+			}
+		}
+
+		// all subdomains share the limit of the first one, as they are the same upstream
+		hosts := make([]string, len(s.mirrors))
+		for i, mirror := range s.mirrors {
+			hosts[i] = s.format(mirror, 0, 0, 0)
+		}
+		// the first url is used for the cache, as the mirrors have the same tiles
+		s.fetcher, err = newFetcher(urls[0], hosts, o)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &s, nil
 }
 
-// setUrl is used to set the Server url
+// setUrl is used to set the Server url, or the urls of its mirrors separated by whitespace, see splitMirrors.
 // The primary purpose of this func is to be able to accept the commonly used ${X}/${x} variables used in strings.
+// Each url is turned into a fmt format string, with arguments as listed in Server.format
 func (s *Server) setURL(url string) {
+	s.mirrors = nil
+	for _, u := range splitMirrors(url) {
+		s.mirrors = append(s.mirrors, toFormat(u))
+	}
+
+	s.server = ""
+	if len(s.mirrors) > 0 {
+		s.server = s.mirrors[0]
+	}
+}
+
+// splitMirrors splits url into the urls of mirrors, which are separated by whitespace.
+// A file:// url is a single path, which may have spaces in it, and is not split.
+func splitMirrors(url string) []string {
+	url = strings.TrimSpace(url)
+	if strings.HasPrefix(url, fileScheme) {
+		return []string{url}
+	}
+	return strings.Fields(url)
+}
+
+// retinaVar is the ${r} variable of tile url templates
+var retinaVar = regexp.MustCompile(`\$?{[rR]}`)
 
+// toFormat turns a tile url template into a fmt format string
+func toFormat(url string) string {
 	replacements := map[*regexp.Regexp]string{
-		regexp.MustCompile(`\$?{[zZ]}`): "%[1]d",
-		regexp.MustCompile(`\$?{[xX]}`): "%[2]d",
-		regexp.MustCompile(`\$?{[yY]}`): "%[3]d",
+		regexp.MustCompile(`\$?{[zZ]}`):  "%[1]d",
+		regexp.MustCompile(`\$?{[xX]}`):  "%[2]d",
+		regexp.MustCompile(`\$?{[yY]}`):  "%[3]d",
+		regexp.MustCompile(`\$?{-[yY]}`): "%[4]d",
+		regexp.MustCompile(`\$?{[sS]}`):  "%[5]s",
+		regexp.MustCompile(`\$?{[qQ]}`):  "%[6]s",
+		retinaVar:                        "%[7]s",
 	}
 
-	s.server = url
+	// literal percent signs, e.g. from url encoding, must survive fmt
+	format := strings.Replace(url, "%", "%%", -1)
 	for re, replacement := range replacements {
-		s.server = re.ReplaceAllString(s.server, replacement)
+		format = re.ReplaceAllString(format, replacement)
 	}
-	// TODO - verify URL format
-	return
+	return format
+}
+
+// url returns the url of a tile on the first mirror
+func (s Server) url(zoom, x, y int) string {
+	return s.format(s.server, zoom, x, y)
+}
+
+// urls returns the urls of a tile on each mirror
+func (s Server) urls(zoom, x, y int) []string {
+	urls := make([]string, len(s.mirrors))
+	for i, mirror := range s.mirrors {
+		urls[i] = s.format(mirror, zoom, x, y)
+	}
+	return urls
+}
+
+// format returns the url of a tile from server, a fmt format string made by toFormat
+func (s Server) format(server string, zoom, x, y int) string {
+	subdomain := ""
+	if len(s.subdomains) > 0 {
+		// the same tile always comes from the same subdomain, which is friendlier to caches
+		subdomain = s.subdomains[abs(x+y)%len(s.subdomains)]
+	}
+
+	retina := ""
+	if s.retina {
+		retina = "@2x"
+	}
+
+	return fmt.Sprintf(server, zoom, x, y, s.flipY(zoom, y), subdomain, quadkey(zoom, x, y), retina)
+}
+
+// flipY returns row y counted from the bottom of the grid instead of the top, for ${-y}.
+// Grids other than Web Mercator can have any number of rows, which is taken from their extent.
+func (s Server) flipY(zoom, y int) int {
+	if s.grid == nil {
+		return (1 << uint(zoom)) - 1 - y
+	}
+	return s.grid.tiles(zoom, s.TileSize()).Max.Y - 1 - y
+}
+
+// hasFlippedY reports if the url of the server has a ${-y} variable
+func (s Server) hasFlippedY() bool {
+	return strings.Contains(s.server, "%[4]d")
+}
+
+// TileSize returns the size of the tiles from the server, including the retina doubling from ${r}
+func (s Server) TileSize() int {
+	if s.retina && s.hasRetina() {
+		return s.tileSize * 2
+	}
+	return s.tileSize
+}
+
+// Grid returns the grid of the tiles, or nil for the Web Mercator grid
+func (s Server) Grid() *Grid {
+	return s.grid
+}
+
+// Scale returns a Server that uses retina tiles for scales of 2 and above, if the url supports ${r}.
+// For other servers the tiles are resized by StaticMap.
+func (s Server) Scale(scale int) TileSource {
+	if scale >= 2 && s.hasRetina() {
+		s.retina = true
+		if s.fetcher != nil {
+			s.fetcher = s.fetcher.retina
+		}
+	}
+	return s
+}
+
+// hasRetina reports if the url of the server has a ${r} variable
+func (s Server) hasRetina() bool {
+	return strings.Contains(s.server, "%[7]s")
 }
 
 // Get returns a image from the tile server
-func (s Server) Get(x, y, zoom int) (image.Image, error) {
+func (s Server) Get(ctx context.Context, zoom, x, y int) (image.Image, error) {
 	if s.server == "" {
 		return nil, errors.New("server URL is missing - use NewServer to initialize the server")
 	}
-	url := fmt.Sprintf(s.server, zoom, x, y)
-
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
 
-	req.Header.Add("User-Agent", "Slipee/0.0 (+https://github.com/krilor/slipee)")
-	// TODO - automate version in UA
-	// TODO HTTP Referrer header
-
-	res, err := client.Do(req)
+	what := fmt.Sprintf("tile %d/%d/%d", zoom, x, y)
+	if s.local {
+		data, err := s.readFile(zoom, x, y)
+		if err != nil {
+			return nil, err
+		}
+		return decode(data, what)
+	}
 
+	data, err := s.fetcher.tile(ctx, s.urls(zoom, x, y), zoom, x, y)
 	if err != nil {
-		fmt.Println("in err")
-		return nil, errors.Wrapf(err, "could not get tile %d/%d/%d", zoom, x, y)
+		return nil, err
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("got status code %d for %s", res.StatusCode, url)
-	}
+	return s.fetcher.decode(data, what)
+}
 
-	img, _, err := image.Decode(res.Body)
+// readFile reads a tile from the local directory of a file:// server
+func (s Server) readFile(zoom, x, y int) ([]byte, error) {
+	path := strings.TrimPrefix(s.url(zoom, x, y), fileScheme)
 
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, errors.Wrapf(ErrNotFound, "tile %d/%d/%d", zoom, x, y)
+	}
 	if err != nil {
-		return nil, errors.Wrapf(err, "could not decode tile %d/%d/%d", zoom, x, y)
+		return nil, errors.Wrapf(err, "could not read tile %d/%d/%d", zoom, x, y)
 	}
 
-	return img, nil
+	return data, nil
 }
 
-// Find returs a tile image based on latitude and longitude.
+// Find returs a tile image from src based on latitude and longitude.
 // The image.Point returned is the pixel coordinate of the lat/long position.
-// Integers returned are the x/y tile numbers
-func (s Server) Find(lat, long float64, zoom int) (image.Image, image.Point, int, int, error) {
+// Integers returned are the x/y tile numbers. The tiles are taken to be in the Web Mercator grid.
+func Find(ctx context.Context, src TileSource, lat, long float64, zoom int) (image.Image, image.Point, int, int, error) {
 	x, y, p := find(lat, long, zoom)
-	img, err := s.Get(x, y, zoom)
+	img, err := src.Get(ctx, zoom, x, y)
 
 	if err != nil {
 		return img, p, 0, 0, errors.Wrapf(err, "could not get tile for %f,%f-%d", lat, long, zoom)
@@ -112,38 +341,309 @@ func (s Server) Find(lat, long float64, zoom int) (image.Image, image.Point, int
 	return img, p, x, y, nil
 }
 
-// StaticMap patches together a image.Image of widht*height with lat and long in center. Zoom is the zoom level.
-func (s Server) StaticMap(width, height, zoom int, lat, long float64) (*image.RGBA, error) {
-	tileX, tileY, p := find(lat, long, zoom)
+// TileSizer is implemented by tile sources with tiles that are not 256x256 pixels
+type TileSizer interface {
+	TileSize() int
+}
 
-	// Now we need to figure out a few things about the image
-	center := image.Point{int(width / 2), int(height / 2)}
-	offset := image.Point{-(256 - (center.X-p.X)%256), -(256 - (center.Y-p.Y)%256)}
-	nX := (width-offset.X)/256 + 1
-	nY := (height-offset.Y)/256 + 1
-	startX := tileX - (center.X-offset.X)/256
-	startY := tileY - (center.Y-offset.Y)/256
+// Scaler is implemented by tile sources that have dedicated tiles for high-DPI maps.
+// Scale returns a tile source for maps with scale times the pixel density of a normal map.
+type Scaler interface {
+	Scale(scale int) TileSource
+}
+
+// Attributor is implemented by tile sources that know who the map data is from, e.g. "© Kartverket"
+type Attributor interface {
+	Attribution() string
+}
+
+// Attribution returns the attribution of src, or an empty string if it does not have one
+func Attribution(src TileSource) string {
+	if a, ok := src.(Attributor); ok {
+		return a.Attribution()
+	}
+	return ""
+}
+
+// tileSize returns the size of the tiles in src
+func tileSize(src TileSource) int {
+	if sizer, ok := src.(TileSizer); ok && sizer.TileSize() > 0 {
+		return sizer.TileSize()
+	}
+	return 256
+}
+
+// StaticMap patches together a image.Image of widht*height from the tiles in src with lat and long in center. Zoom is the zoom level,
+// which may be fractional, e.g. 12.5 for a map halfway between zoom level 12 and 13.
+// Scale is the pixel density, and the image returned is scale*width x scale*height, e.g. a scale of 2 gives a map for high-DPI screens.
+// Getting the tiles is given up when ctx is done, e.g. when the client has gone away or a deadline is passed.
+// The map fails if any of its tiles can not be had, see PartialMap for maps that make do without them.
+//
+// The tiles are in the grid of src, see Gridder, which is Web Mercator for most sources.
+func StaticMap(ctx context.Context, src TileSource, width, height int, zoom float64, scale int, lat, long float64) (*image.RGBA, error) {
+	static, _, err := staticMap(ctx, src, width, height, zoom, scale, lat, long, "")
+	return static, err
+}
+
+// PartialMap is like StaticMap, but tiles that can not be had, e.g. as the tile server is down, are drawn as placeholder instead of failing the map.
+// failed has the errors of those tiles, and the map is degraded if there are any. The map still fails if ctx is done.
+func PartialMap(ctx context.Context, src TileSource, width, height int, zoom float64, scale int, lat, long float64, placeholder Placeholder) (static *image.RGBA, failed []error, err error) {
+	if placeholder == "" {
+		placeholder = Hatch
+	}
+	return staticMap(ctx, src, width, height, zoom, scale, lat, long, placeholder)
+}
+
+// staticMap makes the map of StaticMap, or of PartialMap if placeholder is set
+func staticMap(ctx context.Context, src TileSource, width, height int, zoom float64, scale int, lat, long float64, placeholder Placeholder) (*image.RGBA, []error, error) {
+	if scale < 1 {
+		scale = 1
+	}
+	if scaler, ok := src.(Scaler); ok {
+		src = scaler.Scale(scale)
+	}
+
+	// everything from here is in pixels of the map
+	width, height = width*scale, height*scale
+	g := gridOf(src)
+
+	if renderer, ok := src.(BBoxRenderer); ok {
+		// rendering takes any zoom level, fractional or not
+		return renderBBox(ctx, renderer, g, width, height, g.resolution(zoom)/float64(scale), scale, lat, long, placeholder)
+	}
+
+	z := math.Floor(zoom)
+	if zoom == z {
+		return tileMap(ctx, src, g, width, height, int(z), scale, lat, long, placeholder)
+	}
+
+	// Tiles only come in whole zoom levels. Maps at fractional zoom levels are patched together at the zoom level below,
+	// and scaled up by a factor between 1 and 2. The map at the zoom level below has a margin of a pixel, so that the edges are interpolated too.
+	below, res := g.resolution(z)/float64(scale), g.resolution(zoom)/float64(scale)
+	f := below / res
+	w, h := int(math.Ceil(float64(width)/f))+2, int(math.Ceil(float64(height)/f))+2
+	belowMap, failed, err := tileMap(ctx, src, g, w, h, int(z), scale, lat, long, placeholder)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the center of the map is at the same point in both maps
+	x, y := g.project(lat, long)
+	bx, by := center(g, x, y, below, w, h)
+	cx, cy := center(g, x, y, res, width, height)
+
+	static := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(static, static.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	draw.CatmullRom.Transform(static, f64.Aff3{f, 0, cx - f*bx, 0, f, cy - f*by}, belowMap, belowMap.Bounds(), draw.Src, nil)
+
+	return static, failed, nil
+}
+
+// Pixels returns a function that converts lat and long to a pixel of the map that StaticMap makes with the same arguments,
+// e.g. to draw markers on top of it. The pixels are in the image returned by StaticMap, i.e. multiplied by scale.
+// Points on grids of the whole world are placed on the side of the antimeridian that is nearest the center of the map.
+func Pixels(src TileSource, width, height int, zoom float64, scale int, lat, long float64) func(lat, long float64) (float64, float64) {
+	if scale < 1 {
+		scale = 1
+	}
+	width, height = width*scale, height*scale
+	g := gridOf(src)
+	res := g.resolution(zoom) / float64(scale)
+
+	x, y := g.project(lat, long)
+	left, top := topLeft(g, x, y, res, width, height)
+	cx, _ := g.pixel(x, y, res)
+
+	world := 0.0
+	if g.columns > 0 {
+		world = (g.Extent[2] - g.Extent[0]) / res
+	}
+
+	return func(lat, long float64) (float64, float64) {
+		x, y := g.project(lat, long)
+		px, py := g.pixel(x, y, res)
+		if world > 0 {
+			px -= world * math.Round((px-cx)/world)
+		}
+		return px - float64(left), py - float64(top)
+	}
+}
+
+// topLeft returns the pixel at the top left corner of a width*height image of a map with resolution res and x, y in center.
+// x and y are in the CRS of grid g, and the pixel counts from its origin.
+func topLeft(g *Grid, x, y, res float64, width, height int) (int, int) {
+	px, py := g.pixel(x, y, res)
+	return int(math.Floor(px)) - width/2, int(math.Floor(py)) - height/2
+}
+
+// center returns where x, y is in a width*height image of a map with resolution res and x, y in center, see topLeft
+func center(g *Grid, x, y, res float64, width, height int) (float64, float64) {
+	left, top := topLeft(g, x, y, res, width, height)
+	px, py := g.pixel(x, y, res)
+	return px - float64(left), py - float64(top)
+}
+
+// renderBBox renders a width*height map with resolution res, in the units of the CRS of g per pixel, with lat and long in center.
+// If placeholder is set, a map that can not be rendered is all placeholder, see tileMap.
+func renderBBox(ctx context.Context, renderer BBoxRenderer, g *Grid, width, height int, res float64, scale int, lat, long float64, placeholder Placeholder) (*image.RGBA, []error, error) {
+	x, y := g.project(lat, long)
+	left, top := topLeft(g, x, y, res, width, height)
+
+	minX := g.OriginX + float64(left)*res
+	maxY := g.OriginY - float64(top)*res
+
+	static := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	img, err := renderer.RenderBBox(ctx, minX, maxY-float64(height)*res, minX+float64(width)*res, maxY, width, height)
+	if err != nil {
+		err = errors.Wrap(err, "could not render bbox")
+		if placeholder == "" || ctx.Err() != nil {
+			return nil, nil, err
+		}
+		placeholder.draw(static, static.Bounds(), scale)
+		return static, []error{err}, nil
+	}
+
+	drawTile(static, static.Bounds(), img)
+	return static, nil, nil
+}
+
+// tileMap patches together a width*height map at a whole zoom level from the tiles in src, with lat and long in center.
+// width and height are in pixels of the map, i.e. already multiplied by scale.
+// If placeholder is set, tiles that can not be had are drawn as placeholder, and their errors are returned with the map, instead of failing it.
+func tileMap(ctx context.Context, src TileSource, g *Grid, width, height, zoom, scale int, lat, long float64, placeholder Placeholder) (*image.RGBA, []error, error) {
+	// Tiles that are more detailed than the map are taken from a lower zoom level, e.g. 512px tiles are taken from zoom-1 on normal Web Mercator maps.
+	// Tiles are drawn in size px, and resized if that does not match the tile.
+	// Tiles are never taken from a higher zoom level, as that would change the look of the map, e.g. make labels smaller.
+	res := g.resolution(float64(zoom)) / float64(scale)
+	native := tileSize(src)
+	tileZoom := g.level(res, native, zoom)
+	size := float64(native) * g.levelResolution(tileZoom, native) / res
+	if math.Abs(size-math.Round(size)) < 1e-6 {
+		// whole sizes, like on Web Mercator maps, should stay whole in spite of rounding
+		size = math.Round(size)
+	}
+
+	x, y := g.project(lat, long)
+	left, top := topLeft(g, x, y, res, width, height)
+
+	startX, startY := int(math.Floor(float64(left)/size)), int(math.Floor(float64(top)/size))
+	nX := int(math.Floor(float64(left+width-1)/size)) - startX + 1
+	nY := int(math.Floor(float64(top+height-1)/size)) - startY + 1
 
 	static := image.NewRGBA(image.Rectangle{image.Point{0, 0}, image.Point{width, height}})
+	draw.Draw(static, static.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Tiles of grids of the whole world wrap around the antimeridian, so the same tile can be in the map more than once, e.g. at zoom 0.
+	// Tiles outside the extent of the grid, like the rows beyond the poles, are left as background.
+	extent := g.tiles(tileZoom, native)
+	tile := func(x, y int) (image.Point, bool) {
+		t := image.Pt(startX+x, startY+y)
+		if g.columns > 0 {
+			t.X = wrap(t.X, extent.Dx())
+		}
+		if t.X < 0 || t.Y < 0 || (!extent.Empty() && !t.In(extent)) {
+			return t, false
+		}
+		return t, true
+	}
+
+	index := map[image.Point]int{}
+	var needed []image.Point
 
 	for x := 0; x < nX; x++ {
 		for y := 0; y < nY; y++ {
-			img, err := s.Get(startX+x, startY+y, zoom)
+			t, ok := tile(x, y)
+			if !ok {
+				continue
+			}
+			if _, ok := index[t]; !ok {
+				index[t] = len(needed)
+				needed = append(needed, t)
+			}
+		}
+	}
+
+	// Tiles are fetched concurrently, and drawn in order once all of them are in.
+	// How many requests that actually hit the tile server at once is up to src - Server limits it per host.
+
+	tiles := make([]image.Image, len(needed))
+	errs := make(chan error, len(needed))
+	wg := sync.WaitGroup{}
+
+	for i, t := range needed {
+		wg.Add(1)
+		go func(i int, t image.Point) {
+			defer wg.Done()
+			img, err := src.Get(ctx, tileZoom, t.X, t.Y)
 			if err != nil {
-				return nil, errors.Wrap(err, "could not get tile in loop")
+				errs <- errors.Wrapf(err, "could not get tile %d/%d/%d", tileZoom, t.X, t.Y)
+				if placeholder == "" {
+					cancel() // no need to get the rest of the tiles
+				}
+				return
 			}
+			tiles[i] = img
+		}(i, t)
+	}
+
+	wg.Wait()
+	close(errs)
 
-			draw.Draw(
-				static,
-				image.Rectangle{image.Point{256*x + offset.X, 256*y + offset.Y}, image.Point{256*(x+1) + offset.X, 256*(y+1) + offset.Y}},
-				img,
-				image.Point{0, 0},
-				draw.Src,
+	var failed []error
+	for err := range errs {
+		failed = append(failed, err)
+	}
+
+	// in strict maps, the first error is the interesting one - the others are likely caused by cancel.
+	// Partial maps make do without the tiles, unless they failed because ctx is done.
+	if len(failed) > 0 && (placeholder == "" || ctx.Err() != nil) {
+		return nil, nil, errors.Wrap(failed[0], "could not get tile in loop")
+	}
+
+	for x := 0; x < nX; x++ {
+		for y := 0; y < nY; y++ {
+			t, ok := tile(x, y)
+			if !ok {
+				continue
+			}
+			// the edges are rounded the same way for neighbouring tiles, so that there are no gaps between them
+			r := image.Rect(
+				int(math.Round(float64(startX+x)*size))-left,
+				int(math.Round(float64(startY+y)*size))-top,
+				int(math.Round(float64(startX+x+1)*size))-left,
+				int(math.Round(float64(startY+y+1)*size))-top,
 			)
+			if tiles[index[t]] == nil {
+				placeholder.draw(static, r, scale)
+				continue
+			}
+			drawTile(static, r, tiles[index[t]])
 		}
 	}
 
-	return static, nil
+	return static, failed, nil
+}
+
+// drawTile draws tile into r of dst, and resizes it if it does not fit r
+func drawTile(dst draw.Image, r image.Rectangle, tile image.Image) {
+	if tile.Bounds().Size() == r.Size() {
+		draw.Draw(dst, r, tile, tile.Bounds().Min, draw.Src)
+		return
+	}
+	draw.ApproxBiLinear.Scale(dst, r, tile, tile.Bounds(), draw.Src, nil)
+}
+
+// floorDiv is integer division rounding towards negative infinity
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
 }
 
 // latLongToWebMercator converts from lat and long to Web Mercator
@@ -163,9 +663,9 @@ func latLongToWebMercator(lat, long float64) (float64, float64) {
 // mercatorToPixel takes a lat or long mercator and a zoom level and returns the tile number and pixel within that tile
 // To get the total pixel position, use
 //
-//    absolutePixel = tile * 256 + pixel
-//
+//	absolutePixel = tile * 256 + pixel
 func mercatorToPixel(m float64, zoom int) (int, int) {
-	absolutePixel := int(float64(int(1)<<zoom) * m)
-	return absolutePixel / 256, absolutePixel & 255
+	// floored, so that longitudes west of the antimeridian give negative tiles, that wrap
+	absolutePixel := int(math.Floor(float64(int(1)<<zoom) * m))
+	return floorDiv(absolutePixel, 256), absolutePixel & 255
 }
@@ -1,9 +1,24 @@
 package tile
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
 	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // Used for float comarison
@@ -76,6 +91,12 @@ func TestServerSetURL(t *testing.T) {
 		{"{X}", "%[2]d"},
 		{"{y}", "%[3]d"},
 		{"{Y}", "%[3]d"},
+		{"{-y}", "%[4]d"},
+		{"${-Y}", "%[4]d"},
+		{"{s}", "%[5]s"},
+		{"{q}", "%[6]s"},
+		{"{r}", "%[7]s"},
+		{"${z}/%2F", "%[1]d/%%2F"},
 	}
 
 	for _, test := range urlTest {
@@ -97,3 +118,389 @@ func TestLatLimit(t *testing.T) {
 		t.Errorf("got %s - expected %s", got, want)
 	}
 }
+
+func TestFind(t *testing.T) {
+	var findTest = []struct {
+		lat, long float64
+		zoom      int
+		x, y      int
+	}{
+		{59.926181, 10.775909, 16, 34729, 19058},
+		{89, 0, 2, 2, 0},
+		{-89, 0, 2, 2, 3},
+		{0, 180, 2, 0, 2},
+		{0, -190, 2, 3, 2},
+	}
+
+	for _, test := range findTest {
+		t.Run(fmt.Sprintf("%f,%f-%d", test.lat, test.long, test.zoom), func(t *testing.T) {
+			x, y, _ := find(test.lat, test.long, test.zoom)
+			if x != test.x || y != test.y {
+				t.Errorf("got %d/%d - want %d/%d", x, y, test.x, test.y)
+			}
+		})
+	}
+}
+
+// mockSource is a TileSource that returns uniform tiles and records the tiles requested
+type mockSource struct {
+	size      int
+	mu        sync.Mutex
+	requested map[string]bool
+}
+
+func (m *mockSource) TileSize() int {
+	return m.size
+}
+
+func (m *mockSource) Get(ctx context.Context, z, x, y int) (image.Image, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.requested == nil {
+		m.requested = map[string]bool{}
+	}
+	m.requested[fmt.Sprintf("%d/%d/%d", z, x, y)] = true
+	img := image.NewRGBA(image.Rect(0, 0, tileSize(m), tileSize(m)))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{uint8(x), uint8(y), uint8(z), 255}), image.Point{}, draw.Src)
+	return img, nil
+}
+
+func TestStaticMapSource(t *testing.T) {
+	var sourceTest = []struct {
+		size      int
+		scale     int
+		tileZoom  int
+		imageSize image.Point
+	}{
+		{256, 1, 16, image.Pt(500, 300)},
+		{256, 2, 16, image.Pt(1000, 600)},
+		{512, 1, 15, image.Pt(500, 300)},
+		{512, 2, 16, image.Pt(1000, 600)},
+	}
+
+	for _, test := range sourceTest {
+		t.Run(fmt.Sprintf("%d-%d", test.size, test.scale), func(t *testing.T) {
+			src := &mockSource{size: test.size}
+
+			img, err := StaticMap(context.Background(), src, 500, 300, 16, test.scale, 59.926181, 10.775909)
+			if err != nil {
+				t.Fatalf("got error %s", err)
+			}
+
+			if got := img.Bounds().Size(); got != test.imageSize {
+				t.Errorf("got size %v - want %v", got, test.imageSize)
+			}
+
+			for requested := range src.requested {
+				if !strings.HasPrefix(requested, fmt.Sprintf("%d/", test.tileZoom)) {
+					t.Errorf("got tile %s requested - want zoom %d", requested, test.tileZoom)
+				}
+			}
+
+			tileX, tileY, _ := find(59.926181, 10.775909, test.tileZoom)
+			if !src.requested[fmt.Sprintf("%d/%d/%d", test.tileZoom, tileX, tileY)] {
+				t.Errorf("center tile %d/%d/%d was not requested - got %v", test.tileZoom, tileX, tileY, src.requested)
+			}
+
+			// the center pixel should be drawn from the center tile
+			want := color.RGBA{uint8(tileX), uint8(tileY), uint8(test.tileZoom), 255}
+			if got := img.RGBAAt(test.imageSize.X/2, test.imageSize.Y/2); got != want {
+				t.Errorf("center pixel: got %v - want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestStaticMapWrap(t *testing.T) {
+	var wrapTests = []struct {
+		name          string
+		width, height int
+		zoom          float64
+		lat, long     float64
+		requested     []string
+		// colors at the top left, center and bottom right of the map
+		colors [3]color.RGBA
+	}{
+		{"zoom 0", 600, 400, 0, 0, 0, []string{"0/0/0"}, [3]color.RGBA{background, {0, 0, 0, 255}, background}},
+		{"antimeridian", 300, 200, 2, 0, 179.9, []string{"2/3/1", "2/3/2", "2/0/1", "2/0/2"}, [3]color.RGBA{{3, 1, 2, 255}, {3, 2, 2, 255}, {0, 2, 2, 255}}},
+		{"north pole", 300, 200, 2, 89, 0, []string{"2/1/0", "2/2/0"}, [3]color.RGBA{background, {2, 0, 2, 255}, {2, 0, 2, 255}}},
+		{"south pole", 300, 200, 2, -89, 0, []string{"2/1/3", "2/2/3"}, [3]color.RGBA{{1, 3, 2, 255}, {2, 3, 2, 255}, background}},
+	}
+
+	for _, test := range wrapTests {
+		t.Run(test.name, func(t *testing.T) {
+			src := &mockSource{}
+
+			img, err := StaticMap(context.Background(), src, test.width, test.height, test.zoom, 1, test.lat, test.long)
+			if err != nil {
+				t.Fatalf("got error %s", err)
+			}
+
+			if len(src.requested) != len(test.requested) {
+				t.Errorf("got tiles %v requested - want %v", src.requested, test.requested)
+			}
+			for _, tile := range test.requested {
+				if !src.requested[tile] {
+					t.Errorf("got tiles %v requested - want %s", src.requested, tile)
+				}
+			}
+
+			for i, p := range []image.Point{{0, 0}, {test.width / 2, test.height / 2}, {test.width - 1, test.height - 1}} {
+				if got := color.RGBAModel.Convert(img.At(p.X, p.Y)); got != test.colors[i] {
+					t.Errorf("got %v at %v - want %v", got, p, test.colors[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStaticMapFractionalZoom(t *testing.T) {
+	for _, scale := range []int{1, 2} {
+		t.Run(fmt.Sprintf("scale %d", scale), func(t *testing.T) {
+			src := &mockSource{}
+
+			img, err := StaticMap(context.Background(), src, 300, 200, 2.5, scale, -20, 30)
+			if err != nil {
+				t.Fatalf("got error %s", err)
+			}
+
+			if got, want := img.Bounds().Size(), image.Pt(300*scale, 200*scale); got != want {
+				t.Errorf("got size %v - want %v", got, want)
+			}
+
+			// tiles are taken from the zoom level below
+			for tile := range src.requested {
+				if !strings.HasPrefix(tile, "2/") {
+					t.Errorf("got tile %s requested - want zoom 2 only", tile)
+				}
+			}
+
+			if got, want := img.RGBAAt(150*scale, 100*scale), (color.RGBA{2, 2, 2, 255}); got != want {
+				t.Errorf("center pixel: got %v - want %v", got, want)
+			}
+			if got := img.RGBAAt(0, 0); got == background {
+				t.Errorf("top left pixel is background - want it drawn from a tile")
+			}
+		})
+	}
+}
+
+func TestPixels(t *testing.T) {
+	src := &mockSource{}
+
+	var pixelTests = []struct {
+		name      string
+		zoom      float64
+		scale     int
+		center    [2]float64
+		point     [2]float64
+		wantX     float64
+		wantY     float64
+		tolerance float64
+	}{
+		{"center", 2, 1, [2]float64{59.926181, 10.775909}, [2]float64{59.926181, 10.775909}, 150, 100, 1},
+		{"fractional center", 2.5, 2, [2]float64{-20, 30}, [2]float64{-20, 30}, 300, 200, 1},
+		{"tile edge", 2, 1, [2]float64{0, -90}, [2]float64{0, -90}, 150, 100, 1e-9},
+		{"east", 2, 1, [2]float64{0, -90}, [2]float64{0, -90 + 360.0/1024*10}, 160, 100, 1e-9},
+		{"antimeridian", 2, 1, [2]float64{0, 179.9}, [2]float64{0, -179.9}, 150 + 0.2*1024/360, 100, 1},
+		{"antimeridian west", 2, 1, [2]float64{0, -179.9}, [2]float64{0, 179.9}, 150 - 0.2*1024/360, 100, 1},
+	}
+
+	for _, test := range pixelTests {
+		t.Run(test.name, func(t *testing.T) {
+			pixel := Pixels(src, 300, 200, test.zoom, test.scale, test.center[0], test.center[1])
+			x, y := pixel(test.point[0], test.point[1])
+			if math.Abs(x-test.wantX) > test.tolerance || math.Abs(y-test.wantY) > test.tolerance {
+				t.Errorf("got pixel %g, %g - want %g, %g", x, y, test.wantX, test.wantY)
+			}
+		})
+	}
+
+	// the pixels are where the tiles of the map are
+	img, err := StaticMap(context.Background(), src, 300, 200, 2, 1, 0, -90)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := img.RGBAAt(149, 100), (color.RGBA{0, 2, 2, 255}); got != want {
+		t.Errorf("got %v west of the tile edge - want %v", got, want)
+	}
+	if got, want := img.RGBAAt(150, 100), (color.RGBA{1, 2, 2, 255}); got != want {
+		t.Errorf("got %v east of the tile edge - want %v", got, want)
+	}
+}
+
+// blockingSource is a TileSource that does not return tiles until ctx is done
+type blockingSource struct{}
+
+func (blockingSource) Get(ctx context.Context, z, x, y int) (image.Image, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestStaticMapContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := StaticMap(ctx, blockingSource{}, 500, 300, 16, 1, 59.926181, 10.775909)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v - want context.DeadlineExceeded", err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("took %s to give up - want about 50ms", took)
+	}
+}
+
+// failingSource is a mockSource that fails for the tiles in failing
+type failingSource struct {
+	mockSource
+	failing map[string]bool
+}
+
+func (f *failingSource) Get(ctx context.Context, z, x, y int) (image.Image, error) {
+	if f.failing[fmt.Sprintf("%d/%d/%d", z, x, y)] {
+		return nil, errors.New("got status code 500")
+	}
+	return f.mockSource.Get(ctx, z, x, y)
+}
+
+func TestPartialMap(t *testing.T) {
+	// the bottom right quarter of the map is tile 2/0/2
+	src := &failingSource{failing: map[string]bool{"2/0/2": true}}
+
+	_, err := StaticMap(context.Background(), src, 300, 200, 2, 1, 0, 179.9)
+	if err == nil {
+		t.Errorf("got no error for a failing tile - want one")
+	}
+
+	var partialTests = []struct {
+		placeholder Placeholder
+		zoom        float64
+		// want reports if the bottom right pixel is the placeholder
+		want func(c color.RGBA) bool
+	}{
+		{Hatch, 2, func(c color.RGBA) bool { return c == background || c == hatchLine }},
+		{Transparent, 2, func(c color.RGBA) bool { return c == color.RGBA{} }},
+		{Transparent, 2.5, func(c color.RGBA) bool { return c == color.RGBA{} }},
+	}
+
+	for _, test := range partialTests {
+		t.Run(fmt.Sprintf("%s %g", test.placeholder, test.zoom), func(t *testing.T) {
+			img, failed, err := PartialMap(context.Background(), src, 300, 200, test.zoom, 1, 0, 179.9, test.placeholder)
+			if err != nil {
+				t.Fatalf("got error %s", err)
+			}
+			if len(failed) != 1 || !strings.Contains(failed[0].Error(), "2/0/2") {
+				t.Errorf("got failed %v - want tile 2/0/2", failed)
+			}
+
+			if got, want := img.RGBAAt(150, 100), (color.RGBA{3, 2, 2, 255}); test.zoom == 2 && got != want {
+				t.Errorf("center pixel: got %v - want %v", got, want)
+			}
+			if got := img.RGBAAt(299, 199); !test.want(got) {
+				t.Errorf("bottom right pixel: got %v - want %s", got, test.placeholder)
+			}
+		})
+	}
+
+	// tiles that fail as ctx is done fail the map, as nobody is waiting for it
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err = PartialMap(ctx, blockingSource{}, 500, 300, 16, 1, 59.926181, 10.775909, Hatch)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v - want context.DeadlineExceeded", err)
+	}
+}
+
+func TestParsePlaceholder(t *testing.T) {
+	for s, want := range map[string]Placeholder{"hatch": Hatch, "Transparent": Transparent, "grey": ""} {
+		got, err := ParsePlaceholder(s)
+		if got != want || (err != nil) != (want == "") {
+			t.Errorf("got %q, %v for %s - want %q", got, err, s, want)
+		}
+	}
+}
+
+func TestStaticMapConcurrency(t *testing.T) {
+	buf := bytes.Buffer{}
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 256, 256)))
+
+	mu := sync.Mutex{}
+	active, maxActive, requests := 0, 0, 0
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		requests++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+		w.Write(buf.Bytes())
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	server, err := NewServer(ts.URL+"/{z}/{x}/{y}.png", WithConcurrency(3), WithRateLimit(0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = StaticMap(context.Background(), server, 1000, 1000, 10, 1, 59.926181, 10.775909)
+	if err != nil {
+		t.Fatalf("got error %s", err)
+	}
+
+	if maxActive != 3 {
+		t.Errorf("got %d concurrent requests for %d tiles - want 3", maxActive, requests)
+	}
+}
+
+func TestServerFile(t *testing.T) {
+	// the path has a space in it, which must not be taken for a list of mirrors
+	dir, err := ioutil.TempDir("", "slipee tiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.MkdirAll(filepath.Join(dir, "1", "0"), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(filepath.Join(dir, "1", "0", "1.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, image.NewRGBA(image.Rect(0, 0, 256, 256)))
+	f.Close()
+
+	s, err := NewServer("file://" + filepath.ToSlash(dir) + "/{z}/{x}/{y}.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := s.Get(context.Background(), 1, 0, 1)
+	if err != nil {
+		t.Fatalf("got error %s", err)
+	}
+	if got := img.Bounds().Size(); got != image.Pt(256, 256) {
+		t.Errorf("got size %v - want 256x256", got)
+	}
+
+	_, err = s.Get(context.Background(), 1, 1, 1)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error '%v' for missing tile - want ErrNotFound", err)
+	}
+
+	_, err = NewServer("https://example.com/{z}/{x}/{y}.png file://" + filepath.ToSlash(dir) + "/{z}/{x}/{y}.png")
+	if err == nil {
+		t.Errorf("got no error for a file:// mirror")
+	}
+}
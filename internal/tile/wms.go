@@ -0,0 +1,199 @@
+package tile
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// earthCircumference is the circumference of the earth in web mercator (EPSG:3857) meters
+const earthCircumference = 2 * math.Pi * 6378137
+
+// BBoxRenderer is implemented by tile sources that can render any area of the map in one request, like WMS servers.
+// StaticMap uses RenderBBox instead of getting tiles if a source implements it.
+// The bounding box is in the CRS of the grid of the source, see Gridder, which is web mercator meters (EPSG:3857) for most sources.
+// The image returned must be width x height pixels.
+type BBoxRenderer interface {
+	RenderBBox(ctx context.Context, minX, minY, maxX, maxY float64, width, height int) (image.Image, error)
+}
+
+// WMS is a tile source that gets map images from a WMS server using GetMap requests.
+// Each tile is a GetMap request for the bounding box of the tile in the CRS of the grid, which is EPSG:3857 unless WithGrid is used.
+type WMS struct {
+	base     url.Values
+	url      url.URL
+	tileSize int
+	grid     *Grid
+	// northFirst is set if the BBOX has north before east, as WMS 1.3.0 has for CRSs like EPSG:4326
+	northFirst bool
+	fetcher    *fetcher
+}
+
+// wmsBBox is a WMS source that renders the whole map with a single GetMap request
+type wmsBBox struct {
+	*WMS
+}
+
+// WMS must implement TileSource, TileSizer and Gridder, and wmsBBox must implement BBoxRenderer
+var _ TileSource = (*WMS)(nil)
+var _ TileSizer = (*WMS)(nil)
+var _ Gridder = (*WMS)(nil)
+var _ BBoxRenderer = wmsBBox{}
+
+// NewWMS returns a new WMS tile source for the WMS server at rawurl.
+// The url must have a LAYERS parameter, and can have any other GetMap parameters, like STYLES, FORMAT, TRANSPARENT or VERSION.
+// Parameters that are not present gets defaults, e.g. VERSION=1.3.0 and FORMAT=image/png.
+//
+// If WithWMSBBox is used, the source returned renders the whole map with a single GetMap request, instead of one request per tile.
+func NewWMS(rawurl string, opts ...Option) (TileSource, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	filled, err := fillAPIKey(rawurl, o.apiKey, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid wms url")
+	}
+
+	u, err := url.Parse(filled)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid wms url %s", rawurl)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid wms url %s: unsupported scheme '%s'", rawurl, u.Scheme)
+	}
+
+	// WMS parameter names are case insensitive, so they are normalized to upper case
+	base := url.Values{}
+	for key, values := range u.Query() {
+		base[strings.ToUpper(key)] = values
+	}
+
+	if base.Get("LAYERS") == "" {
+		return nil, fmt.Errorf("invalid wms url %s: LAYERS parameter is missing", rawurl)
+	}
+
+	defaults := map[string]string{
+		"SERVICE": "WMS",
+		"REQUEST": "GetMap",
+		"VERSION": "1.3.0",
+		"FORMAT":  "image/png",
+		"STYLES":  "",
+	}
+	for key, value := range defaults {
+		if _, ok := base[key]; !ok {
+			base.Set(key, value)
+		}
+	}
+
+	grid := o.grid
+	if grid == nil {
+		grid = WebMercator()
+	}
+
+	// WMS 1.3.0 renamed SRS to CRS, and follows the axis order of the CRS
+	crs := "CRS"
+	northFirst := grid.crs.northFirst
+	if strings.HasPrefix(base.Get("VERSION"), "1.1") {
+		crs = "SRS"
+		northFirst = false
+	}
+	base.Set(crs, grid.CRS)
+
+	u.RawQuery = ""
+
+	w := WMS{
+		base:       base,
+		url:        *u,
+		tileSize:   o.tileSize,
+		grid:       grid,
+		northFirst: northFirst,
+	}
+	// the url without a bounding box is used to namespace the cache
+	w.fetcher, err = newFetcher(w.getMapURL(0, 0, 0, 0, w.tileSize, w.tileSize), []string{rawurl}, o)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.wmsBBox {
+		return wmsBBox{&w}, nil
+	}
+
+	return &w, nil
+}
+
+// TileSize returns the size of the tiles requested from the WMS server
+func (w *WMS) TileSize() int {
+	return w.tileSize
+}
+
+// Grid returns the grid of the tiles
+func (w *WMS) Grid() *Grid {
+	return w.grid
+}
+
+// Get returns a tile from the WMS server
+func (w *WMS) Get(ctx context.Context, zoom, x, y int) (image.Image, error) {
+	if zoom > w.grid.maxLevel() {
+		return nil, errors.Wrapf(ErrNotFound, "zoom level %d is not in the grid", zoom)
+	}
+
+	// the size of a tile in the units of the CRS
+	size := w.grid.levelResolution(zoom, w.tileSize) * float64(w.tileSize)
+
+	minX := w.grid.OriginX + float64(x)*size
+	maxY := w.grid.OriginY - float64(y)*size
+
+	data, err := w.fetcher.tile(ctx, []string{w.getMapURL(minX, maxY-size, minX+size, maxY, w.tileSize, w.tileSize)}, zoom, x, y)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.fetcher.decode(data, fmt.Sprintf("tile %d/%d/%d", zoom, x, y))
+}
+
+// RenderBBox gets a width x height image of the bounding box from the WMS server
+func (w wmsBBox) RenderBBox(ctx context.Context, minX, minY, maxX, maxY float64, width, height int) (image.Image, error) {
+	data, h, err := w.fetcher.get(ctx, []string{w.getMapURL(minX, minY, maxX, maxY, width, height)}, nil)
+	if err == nil {
+		err = redact(notImage(h, data), w.fetcher.secrets...)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get map")
+	}
+
+	return w.fetcher.decode(data, "map")
+}
+
+// getMapURL returns the GetMap url for a bounding box in the CRS of the grid
+func (w *WMS) getMapURL(minX, minY, maxX, maxY float64, width, height int) string {
+	q := url.Values{}
+	for key, values := range w.base {
+		q[key] = values
+	}
+
+	bbox := []float64{minX, minY, maxX, maxY}
+	if w.northFirst {
+		bbox = []float64{minY, minX, maxY, maxX}
+	}
+	q.Set("BBOX", strings.Join([]string{formatFloat(bbox[0]), formatFloat(bbox[1]), formatFloat(bbox[2]), formatFloat(bbox[3])}, ","))
+	q.Set("WIDTH", strconv.Itoa(width))
+	q.Set("HEIGHT", strconv.Itoa(height))
+
+	u := w.url
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// formatFloat formats f with the precision needed for bounding boxes
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
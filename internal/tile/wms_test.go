@@ -0,0 +1,140 @@
+package tile
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// wmsStandIn is a WMS server that returns white images of the requested size, and records the requests
+type wmsStandIn struct {
+	mu       sync.Mutex
+	requests []map[string]string
+}
+
+func (s *wmsStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	params := map[string]string{}
+	for key := range q {
+		params[key] = q.Get(key)
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, params)
+	s.mu.Unlock()
+
+	if q.Get("SERVICE") != "WMS" || q.Get("REQUEST") != "GetMap" || q.Get("LAYERS") == "" {
+		http.Error(w, "bad request", 400)
+		return
+	}
+
+	width, _ := strconv.Atoi(q.Get("WIDTH"))
+	height, _ := strconv.Atoi(q.Get("HEIGHT"))
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	png.Encode(w, img)
+}
+
+func TestWMSGet(t *testing.T) {
+	standIn := &wmsStandIn{}
+	ts := httptest.NewServer(standIn)
+	defer ts.Close()
+
+	src, err := Open("wms+" + ts.URL + "/wms?layers=topo&format=image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := src.Get(context.Background(), 1, 1, 0)
+	if err != nil {
+		t.Fatalf("got error %s", err)
+	}
+
+	if got := img.Bounds().Size(); got != image.Pt(256, 256) {
+		t.Errorf("got size %v - want 256x256", got)
+	}
+
+	want := map[string]string{
+		"LAYERS":  "topo",
+		"CRS":     "EPSG:3857",
+		"VERSION": "1.3.0",
+		"BBOX":    "0,0,20037508.342789244,20037508.342789244",
+		"WIDTH":   "256",
+		"HEIGHT":  "256",
+	}
+	for key, value := range want {
+		if got := standIn.requests[0][key]; got != value {
+			t.Errorf("%s: got %s - want %s", key, got, value)
+		}
+	}
+}
+
+func TestWMSStaticMap(t *testing.T) {
+	var staticMapTest = []struct {
+		name     string
+		bbox     bool
+		requests int
+	}{
+		{"tiles", false, 4},
+		{"bbox", true, 1},
+	}
+
+	for _, test := range staticMapTest {
+		t.Run(test.name, func(t *testing.T) {
+			standIn := &wmsStandIn{}
+			ts := httptest.NewServer(standIn)
+			defer ts.Close()
+
+			src, err := NewWMS(ts.URL+"/wms?LAYERS=topo&VERSION=1.1.1", WithWMSBBox(test.bbox))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			img, err := StaticMap(context.Background(), src, 500, 300, 2, 1, 0, 0)
+			if err != nil {
+				t.Fatalf("got error %s", err)
+			}
+
+			if len(standIn.requests) != test.requests {
+				t.Errorf("got %d requests - want %d", len(standIn.requests), test.requests)
+			}
+
+			if got := standIn.requests[0]["SRS"]; got != "EPSG:3857" {
+				t.Errorf("got SRS %s - want EPSG:3857", got)
+			}
+
+			if got := img.RGBAAt(250, 150); got != (color.RGBA{255, 255, 255, 255}) {
+				t.Errorf("got center pixel %v - want white", got)
+			}
+		})
+	}
+}
+
+func TestNewWMSValidation(t *testing.T) {
+	var validationTest = []struct {
+		in    string
+		valid bool
+	}{
+		{"https://example.com/wms?LAYERS=a", true},
+		{"https://example.com/wms?layers=a,b&styles=", true},
+		{"https://example.com/wms", false},
+		{"ftp://example.com/wms?LAYERS=a", false},
+	}
+
+	for _, test := range validationTest {
+		t.Run(test.in, func(t *testing.T) {
+			_, err := NewWMS(test.in)
+			if (err == nil) != test.valid {
+				t.Errorf("got error '%v' - want valid %v", err, test.valid)
+			}
+		})
+	}
+}
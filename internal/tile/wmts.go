@@ -0,0 +1,505 @@
+package tile
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// wmtsCapabilities is the parts of a WMTS 1.0.0 GetCapabilities document that are needed to get tiles
+// https://www.ogc.org/standard/wmts/
+type wmtsCapabilities struct {
+	ProviderName string `xml:"ServiceProvider>ProviderName"`
+	Operations   []struct {
+		Name string `xml:"name,attr"`
+		Gets []struct {
+			Href      string   `xml:"href,attr"`
+			Encodings []string `xml:"Constraint>AllowedValues>Value"`
+		} `xml:"DCP>HTTP>Get"`
+	} `xml:"OperationsMetadata>Operation"`
+	Layers         []wmtsLayer         `xml:"Contents>Layer"`
+	TileMatrixSets []wmtsTileMatrixSet `xml:"Contents>TileMatrixSet"`
+}
+
+type wmtsLayer struct {
+	Identifier string `xml:"Identifier"`
+	Styles     []struct {
+		Identifier string `xml:"Identifier"`
+		IsDefault  bool   `xml:"isDefault,attr"`
+	} `xml:"Style"`
+	Formats    []string `xml:"Format"`
+	Dimensions []struct {
+		Identifier string `xml:"Identifier"`
+		Default    string `xml:"Default"`
+	} `xml:"Dimension"`
+	Links []struct {
+		TileMatrixSet string                 `xml:"TileMatrixSet"`
+		Limits        []wmtsTileMatrixLimits `xml:"TileMatrixSetLimits>TileMatrixLimits"`
+	} `xml:"TileMatrixSetLink"`
+	ResourceURLs []struct {
+		Format       string `xml:"format,attr"`
+		ResourceType string `xml:"resourceType,attr"`
+		Template     string `xml:"template,attr"`
+	} `xml:"ResourceURL"`
+}
+
+type wmtsTileMatrixLimits struct {
+	TileMatrix string `xml:"TileMatrix"`
+	MinTileRow int    `xml:"MinTileRow"`
+	MaxTileRow int    `xml:"MaxTileRow"`
+	MinTileCol int    `xml:"MinTileCol"`
+	MaxTileCol int    `xml:"MaxTileCol"`
+}
+
+type wmtsTileMatrixSet struct {
+	Identifier   string `xml:"Identifier"`
+	SupportedCRS string `xml:"SupportedCRS"`
+	TileMatrices []struct {
+		Identifier       string  `xml:"Identifier"`
+		ScaleDenominator float64 `xml:"ScaleDenominator"`
+		TopLeftCorner    string  `xml:"TopLeftCorner"`
+		TileWidth        int     `xml:"TileWidth"`
+		TileHeight       int     `xml:"TileHeight"`
+		MatrixWidth      int     `xml:"MatrixWidth"`
+		MatrixHeight     int     `xml:"MatrixHeight"`
+	} `xml:"TileMatrix"`
+}
+
+// pixelSize is the size of a pixel in meters that WMTS scale denominators are based on
+const pixelSize = 0.28e-3
+
+// WMTS is a tile source that gets tiles from a WMTS server, set up from its GetCapabilities document.
+// The url template, the grid, the zoom levels and the attribution are all taken from the document.
+type WMTS struct {
+	template    string   // the tile url, with {TileMatrix}, {TileRow} and {TileCol} left to fill in
+	matrices    []string // the identifiers of the tile matrices, for each zoom level
+	limits      map[string]image.Rectangle
+	grid        *Grid
+	tileSize    int
+	maxZoom     int
+	attribution string
+	fetcher     *fetcher
+}
+
+// WMTS must implement TileSource, TileSizer, Gridder and Attributor
+var _ TileSource = (*WMTS)(nil)
+var _ TileSizer = (*WMTS)(nil)
+var _ Gridder = (*WMTS)(nil)
+var _ Attributor = (*WMTS)(nil)
+
+// NewWMTS returns a tile source for a layer of a WMTS server. rawurl is the url of the GetCapabilities document,
+// either on the server or in a local file with a file:// url, with the layer to use in the fragment, e.g.
+//
+//	https://example.com/wmts/1.0.0/WMTSCapabilities.xml#layer=topo&style=default&tilematrixset=utm33n&format=image/png
+//
+// The layer can be left out if there is only one, the style defaults to the default style of the layer,
+// the tile matrix set defaults to the first one of the layer that is in a supported CRS,
+// and the format defaults to png or jpeg, if the layer has them.
+func NewWMTS(rawurl string, opts ...Option) (*WMTS, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	location, fragment := rawurl, ""
+	if i := strings.LastIndex(rawurl, "#"); i >= 0 {
+		location, fragment = rawurl[:i], rawurl[i+1:]
+	}
+	choice, err := url.ParseQuery(fragment)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid wmts layer choice '%s'", fragment)
+	}
+	for key, values := range choice {
+		choice[strings.ToLower(key)] = values
+	}
+
+	data, err := readCapabilities(location, o)
+	if err != nil {
+		return nil, err
+	}
+
+	var caps wmtsCapabilities
+	err = xml.Unmarshal(data, &caps)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse wmts capabilities %s", location)
+	}
+
+	w, err := caps.source(choice.Get("layer"), choice.Get("style"), choice.Get("tilematrixset"), choice.Get("format"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not use wmts capabilities %s", location)
+	}
+
+	// the url with the tile matrix set filled in is used to namespace the cache
+	w.fetcher, err = newFetcher(w.template, []string{w.template}, o)
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// readCapabilities reads the capabilities document at location, which is a http(s):// or file:// url
+func readCapabilities(location string, o options) ([]byte, error) {
+	if strings.HasPrefix(location, fileScheme) {
+		data, err := ioutil.ReadFile(strings.TrimPrefix(location, fileScheme))
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read wmts capabilities")
+		}
+		return data, nil
+	}
+
+	filled, err := fillAPIKey(location, o.apiKey, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid wmts capabilities url")
+	}
+	u, err := url.Parse(filled)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid wmts capabilities url %s", location)
+	}
+
+	// the document is not tiles, so it is not cached
+	o.cacheDir = ""
+	f, err := newFetcher(location, []string{filled}, o)
+	if err != nil {
+		return nil, err
+	}
+
+	data, _, err := f.get(context.Background(), []string{filled}, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get wmts capabilities")
+	}
+	return data, nil
+}
+
+// source returns a WMTS for the layer, style, tile matrix set and format, with defaults for the ones that are empty
+func (c *wmtsCapabilities) source(layerID, style, setID, format string) (*WMTS, error) {
+	layer, err := c.layer(layerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if style == "" {
+		for _, s := range layer.Styles {
+			if s.IsDefault || style == "" {
+				style = s.Identifier
+			}
+		}
+	}
+
+	if format == "" {
+		format = preferredFormat(layer.Formats)
+	}
+
+	w := WMTS{}
+	if c.ProviderName != "" {
+		w.attribution = "© " + c.ProviderName
+	}
+
+	// the tile matrix set must be linked to the layer, and be in a CRS that is supported here
+	var set *wmtsTileMatrixSet
+	for _, link := range layer.Links {
+		if setID != "" && link.TileMatrixSet != setID {
+			continue
+		}
+		s := c.tileMatrixSet(link.TileMatrixSet)
+		if s == nil {
+			continue
+		}
+		w.grid, w.tileSize, err = s.grid()
+		if err != nil {
+			if setID != "" {
+				return nil, errors.Wrapf(err, "tile matrix set %s can not be used", setID)
+			}
+			continue
+		}
+
+		set = s
+		w.limits = map[string]image.Rectangle{}
+		for _, l := range link.Limits {
+			w.limits[l.TileMatrix] = image.Rect(l.MinTileCol, l.MinTileRow, l.MaxTileCol+1, l.MaxTileRow+1)
+		}
+		break
+	}
+	if set == nil {
+		if setID != "" {
+			return nil, fmt.Errorf("layer %s does not have tile matrix set %s", layer.Identifier, setID)
+		}
+		return nil, fmt.Errorf("layer %s has no tile matrix set in a supported CRS", layer.Identifier)
+	}
+
+	// the zoom levels are the tile matrices, and the layer can stop before the last one
+	w.maxZoom = len(set.TileMatrices) - 1
+	for z, m := range set.TileMatrices {
+		w.matrices = append(w.matrices, m.Identifier)
+		if _, ok := w.limits[m.Identifier]; ok || len(w.limits) == 0 {
+			w.maxZoom = z
+		}
+	}
+	if len(w.grid.Resolutions) > w.maxZoom+1 {
+		w.grid.Resolutions = w.grid.Resolutions[:w.maxZoom+1]
+	}
+
+	w.template, err = c.template(layer, style, format, set.Identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	return &w, nil
+}
+
+// layer returns the layer with identifier id, or the only layer if id is empty
+func (c *wmtsCapabilities) layer(id string) (*wmtsLayer, error) {
+	if id == "" {
+		if len(c.Layers) != 1 {
+			return nil, fmt.Errorf("there are %d layers, choose one with #layer=", len(c.Layers))
+		}
+		return &c.Layers[0], nil
+	}
+
+	for i := range c.Layers {
+		if c.Layers[i].Identifier == id {
+			return &c.Layers[i], nil
+		}
+	}
+	return nil, fmt.Errorf("layer %s not found", id)
+}
+
+// tileMatrixSet returns the tile matrix set with identifier id, or nil if there is none
+func (c *wmtsCapabilities) tileMatrixSet(id string) *wmtsTileMatrixSet {
+	for i := range c.TileMatrixSets {
+		if c.TileMatrixSets[i].Identifier == id {
+			return &c.TileMatrixSets[i]
+		}
+	}
+	return nil
+}
+
+// template returns the tile url template of the layer, with everything but {TileMatrix}, {TileRow} and {TileCol} filled in.
+// The RESTful template of the layer is used if there is one, and the KVP GetTile request otherwise.
+func (c *wmtsCapabilities) template(layer *wmtsLayer, style, format, set string) (string, error) {
+	values := map[string]string{
+		"{Style}":         style,
+		"{TileMatrixSet}": set,
+	}
+	for _, d := range layer.Dimensions {
+		values["{"+d.Identifier+"}"] = d.Default
+	}
+
+	for _, r := range layer.ResourceURLs {
+		if r.ResourceType != "tile" || (format != "" && r.Format != format) {
+			continue
+		}
+		template := r.Template
+		for placeholder, value := range values {
+			template = strings.Replace(template, placeholder, value, -1)
+		}
+		return template, nil
+	}
+
+	for _, op := range c.Operations {
+		if op.Name != "GetTile" {
+			continue
+		}
+		for _, get := range op.Gets {
+			if len(get.Encodings) > 0 && !contains(get.Encodings, "KVP") {
+				continue
+			}
+
+			q := []string{"SERVICE=WMTS", "REQUEST=GetTile", "VERSION=1.0.0"}
+			for _, p := range [][2]string{{"LAYER", layer.Identifier}, {"STYLE", style}, {"FORMAT", format}, {"TILEMATRIXSET", set}} {
+				q = append(q, p[0]+"="+url.QueryEscape(p[1]))
+			}
+			for _, d := range layer.Dimensions {
+				q = append(q, url.QueryEscape(d.Identifier)+"="+url.QueryEscape(d.Default))
+			}
+			q = append(q, "TILEMATRIX={TileMatrix}", "TILEROW={TileRow}", "TILECOL={TileCol}")
+
+			base := get.Href
+			if !strings.HasSuffix(base, "?") && !strings.HasSuffix(base, "&") {
+				if strings.Contains(base, "?") {
+					base += "&"
+				} else {
+					base += "?"
+				}
+			}
+			return base + strings.Join(q, "&"), nil
+		}
+	}
+
+	return "", fmt.Errorf("layer %s has no tile url for format %s", layer.Identifier, format)
+}
+
+// grid returns the grid and the tile size of the tile matrix set.
+// Sets that are the same as the Web Mercator or WGS84 grids of the whole world get those grids, so that they wrap around the antimeridian.
+func (s *wmtsTileMatrixSet) grid() (*Grid, int, error) {
+	if len(s.TileMatrices) == 0 {
+		return nil, 0, errors.New("there are no tile matrices")
+	}
+
+	code, err := parseCRS(s.SupportedCRS)
+	if err != nil {
+		return nil, 0, err
+	}
+	c, err := crsByCode(code)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// scale denominators are for meters, and degrees are meters along the equator
+	metersPerUnit := 1.0
+	if _, ok := c.proj.(geographic); ok {
+		metersPerUnit = 2 * math.Pi * semiMajorAxis / 360
+	}
+
+	// corners follow the axis order of the CRS, which is east first for CRS:84, even if it is taken as EPSG:4326
+	crs := strings.ToUpper(s.SupportedCRS)
+	northFirst := c.northFirst && crs != "CRS:84" && !strings.HasSuffix(crs, "CRS84")
+
+	first := s.TileMatrices[0]
+	size := first.TileWidth
+	originX, originY, err := parseCorner(first.TopLeftCorner, northFirst)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var resolutions []float64
+	for _, m := range s.TileMatrices {
+		if m.TileWidth != size || m.TileHeight != size {
+			return nil, 0, fmt.Errorf("tile matrix %s does not have %dx%d tiles like the others", m.Identifier, size, size)
+		}
+		x, y, err := parseCorner(m.TopLeftCorner, northFirst)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !approx(x, originX) || !approx(y, originY) {
+			return nil, 0, fmt.Errorf("tile matrix %s does not have the same top left corner as the others", m.Identifier)
+		}
+		resolutions = append(resolutions, m.ScaleDenominator*pixelSize/metersPerUnit)
+	}
+
+	// the extent is the tiles of the first tile matrix
+	span := resolutions[0] * float64(size)
+	extent := [4]float64{originX, originY - float64(first.MatrixHeight)*span, originX + float64(first.MatrixWidth)*span, originY}
+
+	g, err := NewGrid(s.SupportedCRS, originX, originY, resolutions, extent)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, world := range []*Grid{WebMercator(), WGS84()} {
+		if g.CRS == world.CRS && world.sameLevels(g, size) {
+			return world, size, nil
+		}
+	}
+
+	return g, size, nil
+}
+
+// sameLevels reports if the world grid g has the same zoom levels as the custom grid o, with tiles of size px
+func (g *Grid) sameLevels(o *Grid, size int) bool {
+	if !approx(g.OriginX, o.OriginX) || !approx(g.OriginY, o.OriginY) {
+		return false
+	}
+	for z, res := range o.Resolutions {
+		if !approx(res, g.levelResolution(z, size)) {
+			return false
+		}
+	}
+	return true
+}
+
+// approx reports if a and b are the same, give or take the rounding in capabilities documents
+func approx(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-6*math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+}
+
+// parseCorner parses a TopLeftCorner, which follows the axis order of the CRS
+func parseCorner(s string, northFirst bool) (float64, float64, error) {
+	parts := strings.Fields(s)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid top left corner '%s'", s)
+	}
+	a, errA := strconv.ParseFloat(parts[0], 64)
+	b, errB := strconv.ParseFloat(parts[1], 64)
+	if errA != nil || errB != nil {
+		return 0, 0, fmt.Errorf("invalid top left corner '%s'", s)
+	}
+	if northFirst {
+		return b, a, nil
+	}
+	return a, b, nil
+}
+
+// preferredFormat returns png or jpeg if they are in formats, or the first format
+func preferredFormat(formats []string) string {
+	for _, f := range []string{"image/png", "image/jpeg"} {
+		if contains(formats, f) {
+			return f
+		}
+	}
+	if len(formats) > 0 {
+		return formats[0]
+	}
+	return ""
+}
+
+// contains reports if list has s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// TileSize returns the size of the tiles of the tile matrix set
+func (w *WMTS) TileSize() int {
+	return w.tileSize
+}
+
+// Grid returns the grid of the tile matrix set
+func (w *WMTS) Grid() *Grid {
+	return w.grid
+}
+
+// Attribution returns the attribution of the WMTS server, from the provider in the capabilities
+func (w *WMTS) Attribution() string {
+	return w.attribution
+}
+
+// Get returns a tile from the WMTS server. Tiles outside the limits of the layer are not found, without asking for them.
+func (w *WMTS) Get(ctx context.Context, zoom, x, y int) (image.Image, error) {
+	if zoom < 0 || zoom > w.maxZoom {
+		return nil, errors.Wrapf(ErrNotFound, "zoom level %d is not in the tile matrix set", zoom)
+	}
+
+	matrix := w.matrices[zoom]
+	if len(w.limits) > 0 {
+		limit, ok := w.limits[matrix]
+		if !ok || !image.Pt(x, y).In(limit) {
+			return nil, errors.Wrapf(ErrNotFound, "tile %d/%d/%d is outside the layer", zoom, x, y)
+		}
+	}
+
+	u := strings.NewReplacer(
+		"{TileMatrix}", matrix,
+		"{TileRow}", strconv.Itoa(y),
+		"{TileCol}", strconv.Itoa(x),
+	).Replace(w.template)
+
+	data, err := w.fetcher.tile(ctx, []string{u}, zoom, x, y)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.fetcher.decode(data, fmt.Sprintf("tile %d/%d/%d", zoom, x, y))
+}
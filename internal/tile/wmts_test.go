@@ -0,0 +1,126 @@
+package tile
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// wmtsStandIn is a WMTS server with the capabilities in testdata/wmts.xml, that records the tiles asked for
+type wmtsStandIn struct {
+	url      string
+	mu       sync.Mutex
+	requests []string
+}
+
+func (s *wmtsStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/caps.xml" {
+		data, err := ioutil.ReadFile("testdata/wmts.xml")
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Write([]byte(strings.Replace(string(data), "{{server}}", s.url, -1)))
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, r.URL.RequestURI())
+	s.mu.Unlock()
+
+	png.Encode(w, image.NewRGBA(image.Rect(0, 0, 256, 256)))
+}
+
+func TestWMTS(t *testing.T) {
+	var wmtsTest = []struct {
+		name    string
+		choice  string
+		crs     string
+		z, x, y int
+		request string
+	}{
+		{"restful", "#layer=topo", "EPSG:25833", 1, 1, 1, "/tiles/2024/default/utm33n/utm33n:1/1/1.png"},
+		{"past the last zoom level", "#layer=topo", "EPSG:25833", 2, 2, 3, "/tiles/2024/default/utm33n/utm33n:1/1/1.png"},
+		{"format and tile matrix set", "#layer=topo&tilematrixset=google&format=image/jpeg", "EPSG:3857", 1, 1, 0, "/tiles/2024/default/google/1/0/1.jpg"},
+		{"kvp", "#layer=grey", "EPSG:3857", 1, 1, 0, "/kvp?SERVICE=WMTS&REQUEST=GetTile&VERSION=1.0.0&LAYER=grey&STYLE=default&FORMAT=image%2Fpng&TILEMATRIXSET=google&TILEMATRIX=1&TILEROW=0&TILECOL=1"},
+	}
+
+	for _, test := range wmtsTest {
+		t.Run(test.name, func(t *testing.T) {
+			standIn := &wmtsStandIn{}
+			ts := httptest.NewServer(standIn)
+			defer ts.Close()
+			standIn.url = ts.URL
+
+			src, err := Open("wmts+" + ts.URL + "/caps.xml" + test.choice)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := gridOf(src).CRS; got != test.crs {
+				t.Errorf("got CRS %s - want %s", got, test.crs)
+			}
+			if got := Attribution(src); got != "© Test Mapping Authority" {
+				t.Errorf("got attribution %s", got)
+			}
+
+			_, err = src.Get(context.Background(), test.z, test.x, test.y)
+			if err != nil {
+				t.Fatalf("got error %s", err)
+			}
+
+			if len(standIn.requests) != 1 || standIn.requests[0] != test.request {
+				t.Errorf("got requests %v - want %s", standIn.requests, test.request)
+			}
+		})
+	}
+}
+
+func TestWMTSLimits(t *testing.T) {
+	standIn := &wmtsStandIn{}
+	ts := httptest.NewServer(standIn)
+	defer ts.Close()
+	standIn.url = ts.URL
+
+	src, err := NewWMTS(ts.URL + "/caps.xml#layer=topo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tile := range [][3]int{{1, 2, 0}, {2, 0, 0}, {0, 1, 0}} {
+		_, err := src.Get(context.Background(), tile[0], tile[1], tile[2])
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("got error %v for tile %v - want ErrNotFound", err, tile)
+		}
+	}
+
+	if len(standIn.requests) > 0 {
+		t.Errorf("got requests %v for tiles outside the layer", standIn.requests)
+	}
+}
+
+func TestWMTSInvalid(t *testing.T) {
+	standIn := &wmtsStandIn{}
+	ts := httptest.NewServer(standIn)
+	defer ts.Close()
+	standIn.url = ts.URL
+
+	for _, choice := range []string{"", "#layer=nope", "#layer=topo&tilematrixset=nope", "#layer=grey&tilematrixset=utm33n"} {
+		_, err := NewWMTS(ts.URL + "/caps.xml" + choice)
+		if err == nil {
+			t.Errorf("got no error for %s", choice)
+		}
+	}
+
+	_, err := NewWMTS(ts.URL + "/missing.xml#layer=topo")
+	if err == nil {
+		t.Error("got no error for a capabilities document that is not there")
+	}
+}
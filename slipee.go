@@ -1,39 +1,78 @@
 package main
 
 import (
-	// image formats supported are commonly jpg or png
-
+	"context"
 	"flag"
 	"fmt"
-	_ "image/jpeg"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/krilor/slipee/internal/env"
 	"github.com/krilor/slipee/internal/query"
 	"github.com/krilor/slipee/internal/stitch"
 	"github.com/krilor/slipee/internal/tile"
+	"github.com/pkg/errors"
 )
 
 var s stitch.Stitcher
 
+// version is set by goreleaser, using -ldflags "-X main.version=..."
+var version = "dev"
+
+// sources are the named tile sources that can be used as layers, and styles are named layer specs
+var sources map[string]tile.TileSource
+var styles map[string]string
+
+// icons are the custom icons for markers
+var icons stitch.Icons
+
 // config holds cli variables
 var config struct {
-	lat        float64
-	long       float64
-	width      int
-	height     int
-	zoom       int
-	tileserver string
-	address    string
-	port       int
-	label      string
-	pronto     bool
-	queue      int
-	cache      string
+	lat            float64
+	long           float64
+	width          int
+	height         int
+	zoom           float64
+	tileserver     string
+	address        string
+	port           int
+	label          string
+	pronto         bool
+	queue          int
+	cache          string
+	tilecache      string
+	concurrency    int
+	subdomains     string
+	retina         bool
+	tilesize       int
+	wmsbbox        bool
+	sources        string
+	maxnativezoom  int
+	grid           string
+	ratelimit      float64
+	burst          int
+	headers        string
+	apikeyenv      string
+	referer        string
+	timeout        time.Duration
+	connecttimeout time.Duration
+	readtimeout    time.Duration
+	proxy          string
+	tlscert        string
+	tlskey         string
+	tlsca          string
+	tolerant       bool
+	placeholder    string
+	maxage         time.Duration
+	degradedmaxage time.Duration
+	icons          string
 }
 
 func init() {
@@ -41,14 +80,40 @@ func init() {
 	flag.Float64Var(&config.long, "long", env.Float64("SLIPEE_LONG", 0.0), "longitude")
 	flag.IntVar(&config.width, "width", env.Int("SLIPEE_WIDTH", 500), "width in pixels")
 	flag.IntVar(&config.height, "height", env.Int("SLIPEE_HEIGHT", 500), "width in pixels")
-	flag.IntVar(&config.zoom, "zoom", env.Int("SLIPEE_ZOOM", 16), "zoom level")
+	flag.Float64Var(&config.zoom, "zoom", env.Float64("SLIPEE_ZOOM", 16), "zoom level, may be fractional")
 	flag.StringVar(&config.address, "address", env.String("SLIPEE_ADDRESS", ""), "the address to listen on")
 	flag.IntVar(&config.port, "port", env.Int("SLIPEE_PORT", 7654), "port to listen on")
-	flag.StringVar(&config.tileserver, "tileserver", env.String("SLIPEE_TILESERVER", "https://a.tile.openstreetmap.org/${z}/${x}/${y}.png"), "the tile server url with ${[xyz]} type variables")
-	flag.StringVar(&config.label, "label", env.String("SLIPEE_LABEL", "Slipee | © OpenStreetMap contributors"), "the label to add to the image")
+	flag.StringVar(&config.tileserver, "tileserver", env.String("SLIPEE_TILESERVER", "https://a.tile.openstreetmap.org/${z}/${x}/${y}.png"), "the tile server url with ${[xyz]} type variables, and optionally ${-y}, ${q}, ${s} and ${r}, or several space separated urls of mirrors, a file:// url template to a local directory, a wms+http(s):// url to a WMS server, a wmts+http(s):// or wmts+file:// url to WMTS capabilities, or a mbtiles:// or pmtiles:// path to a MBTiles or PMTiles file")
+	flag.StringVar(&config.subdomains, "subdomains", env.String("SLIPEE_SUBDOMAINS", "abc"), "subdomains for ${s} in the tile server url, as letters (abc) or a comma separated list")
+	flag.BoolVar(&config.retina, "retina", env.Bool("SLIPEE_RETINA", false), "use @2x tiles for ${r} in the tile server url")
+	flag.IntVar(&config.tilesize, "tilesize", env.Int("SLIPEE_TILESIZE", 256), "size of the tiles from the tile server in pixels, e.g. 512")
+	flag.BoolVar(&config.wmsbbox, "wmsbbox", env.Bool("SLIPEE_WMSBBOX", false), "get the whole map from a wms+http(s) tile server in one request, instead of per tile")
+	flag.IntVar(&config.maxnativezoom, "maxnativezoom", env.Int("SLIPEE_MAXNATIVEZOOM", 0), "the highest zoom level the tile server has tiles for, higher zoom levels are made by scaling up its tiles, 0 if unknown")
+	flag.StringVar(&config.grid, "grid", env.String("SLIPEE_GRID", ""), "the grid of the tile server if it is not web mercator, EPSG:4326 - custom grids can be set up in the sources file")
+	flag.StringVar(&config.sources, "sources", env.String("SLIPEE_SOURCES", ""), "a json file with named tile sources and styles, that can be stacked as layers")
+	flag.StringVar(&config.headers, "headers", env.String("SLIPEE_HEADERS", ""), "headers for the tile server requests, as 'Name: value' pairs separated by |, where ${apikey} is replaced with the api key")
+	flag.StringVar(&config.apikeyenv, "apikeyenv", env.String("SLIPEE_APIKEYENV", "SLIPEE_APIKEY"), "the environment variable with the api key for ${apikey} in the tile server url and headers")
+	flag.StringVar(&config.referer, "referer", env.String("SLIPEE_REFERER", ""), "the Referer header for the tile server requests")
+	flag.DurationVar(&config.connecttimeout, "connecttimeout", env.Duration("SLIPEE_CONNECTTIMEOUT", 10*time.Second), "timeout for connecting to the tile server, 0 for no timeout")
+	flag.DurationVar(&config.readtimeout, "readtimeout", env.Duration("SLIPEE_READTIMEOUT", 30*time.Second), "timeout for getting a tile from the tile server, 0 for no timeout")
+	flag.StringVar(&config.proxy, "proxy", env.String("SLIPEE_PROXY", ""), "the HTTP proxy for tile server requests, the default is taken from HTTP_PROXY/HTTPS_PROXY/NO_PROXY")
+	flag.StringVar(&config.tlscert, "tlscert", env.String("SLIPEE_TLSCERT", ""), "PEM client certificate file for tile servers that require mutual TLS")
+	flag.StringVar(&config.tlskey, "tlskey", env.String("SLIPEE_TLSKEY", ""), "PEM private key file for tlscert")
+	flag.StringVar(&config.tlsca, "tlsca", env.String("SLIPEE_TLSCA", ""), "PEM CA bundle file to trust for tile servers, in addition to the system CAs")
+	flag.StringVar(&config.icons, "icons", env.String("SLIPEE_ICONS", ""), "a directory with png files to use as custom marker icons, named by the file name without .png")
+	flag.StringVar(&config.label, "label", env.String("SLIPEE_LABEL", defaultLabel), "the label to add to the image, the default has the attribution of WMTS sources instead of OpenStreetMap for them")
 	flag.BoolVar(&config.pronto, "pronto", env.Bool("SLIPEE_PRONTO", false), "if clients are allowed to buypass queue and ask for static images promtly")
 	flag.IntVar(&config.queue, "queue", env.Int("SLIPEE_QUEUE", 1000), "queue size")
+	flag.DurationVar(&config.timeout, "timeout", env.Duration("SLIPEE_TIMEOUT", 30*time.Second), "the deadline for making an image, 0 for no deadline")
+	flag.BoolVar(&config.tolerant, "tolerant", env.Bool("SLIPEE_TOLERANT", false), "make images even if some tiles fail, with placeholders for them, instead of failing the image")
+	flag.StringVar(&config.placeholder, "placeholder", env.String("SLIPEE_PLACEHOLDER", "hatch"), "what failed tiles are drawn as in tolerant mode, hatch or transparent")
+	flag.DurationVar(&config.maxage, "maxage", env.Duration("SLIPEE_MAXAGE", 24*time.Hour), "how long clients may cache images")
+	flag.DurationVar(&config.degradedmaxage, "degradedmaxage", env.Duration("SLIPEE_DEGRADEDMAXAGE", 5*time.Minute), "how long clients may cache degraded images, with placeholders for failed tiles, which are made again when asked for after this")
 	flag.StringVar(&config.cache, "cache", env.String("SLIPEE_CACHE", "./slipee_cache"), "directory for cached maps")
+	flag.IntVar(&config.concurrency, "concurrency", env.Int("SLIPEE_CONCURRENCY", 2), "maximum concurrent requests per tile server host")
+	flag.Float64Var(&config.ratelimit, "ratelimit", env.Float64("SLIPEE_RATELIMIT", 4), "maximum requests per second per tile server host, 0 for no limit")
+	flag.IntVar(&config.burst, "burst", env.Int("SLIPEE_BURST", 8), "maximum requests in a burst per tile server host, above ratelimit")
+	flag.StringVar(&config.tilecache, "tilecache", env.String("SLIPEE_TILECACHE", ""), "directory for cached raw tiles, which are never evicted, empty to disable")
 
 	flag.Usage = func() {
 		fmt.Println(`USAGE:
@@ -100,15 +165,100 @@ func main() {
 	}
 }
 
+// defaultLabel is the label of the images unless -label says otherwise
+const defaultLabel = "Slipee | © OpenStreetMap contributors"
+
+// label returns the label for a map of layers, or of the default source if there are no layers.
+// The default label gets the attribution of the source instead of OpenStreetMap, if the source has one.
+func label(layers []tile.Layer) string {
+	if config.label != defaultLabel {
+		return config.label
+	}
+
+	src := sources[defaultSource]
+	if len(layers) > 0 {
+		src = tile.NewComposite(layers...)
+	}
+
+	if attribution := tile.Attribution(src); attribution != "" {
+		return "Slipee | " + attribution
+	}
+	return config.label
+}
+
 // serve handles the serve command
 func serve() {
 
 	// TODO - can we make things work without globals?
-	s = stitch.New(tile.NewServer(config.tileserver), config.queue, config.cache)
+	opts := []tile.Option{
+		tile.WithConcurrency(config.concurrency),
+		tile.WithSubdomains(config.subdomains),
+		tile.WithRetina(config.retina),
+		tile.WithTileSize(config.tilesize),
+		tile.WithWMSBBox(config.wmsbbox),
+		tile.WithMaxNativeZoom(config.maxnativezoom),
+		tile.WithRateLimit(config.ratelimit, config.burst),
+		tile.WithAPIKey(os.Getenv(config.apikeyenv)),
+		tile.WithReferer(config.referer),
+		tile.WithUserAgent(userAgent()),
+		tile.WithConnectTimeout(config.connecttimeout),
+		tile.WithReadTimeout(config.readtimeout),
+		tile.WithProxy(config.proxy),
+		tile.WithClientCert(config.tlscert, config.tlskey),
+		tile.WithCA(config.tlsca),
+	}
+	if config.tilecache != "" {
+		opts = append(opts, tile.WithCache(config.tilecache))
+	}
+
+	headers, err := tile.ParseHeaders(config.headers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts = append(opts, tile.WithHeaders(headers))
+
+	if config.grid != "" {
+		grid, err := tile.GridByName(config.grid)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, tile.WithGrid(grid))
+	}
+
+	src, err := tile.Open(config.tileserver, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sources, styles, err = loadSources(config.sources, src, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if config.icons != "" {
+		icons, err = stitch.LoadIcons(config.icons)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var placeholder tile.Placeholder
+	if config.tolerant {
+		placeholder, err = tile.ParsePlaceholder(config.placeholder)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	s = stitch.New(src, config.queue, config.cache, config.timeout, placeholder, config.degradedmaxage)
 	s.StartWorker()
 
 	http.HandleFunc("/", static)
-	log.Printf("server config: %+v\n", config)
+	// the proxy url and the headers can have credentials in them
+	logged := config
+	logged.proxy = redactUserinfo(config.proxy)
+	logged.headers = redactHeaders(headers)
+	log.Printf("server config: %+v\n", logged)
 	log.Printf("listening on %s:%d\n", config.address, config.port)
 	log.Fatal(http.ListenAndServe(fmt.Sprintf("%s:%d", config.address, config.port), nil))
 
@@ -139,9 +289,9 @@ func static(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// zoom
-	minZoom := 0
-	maxZoom := 23
-	zoom, _, err := query.Int(uv, "zoom", config.zoom, &minZoom, &maxZoom)
+	minZoom := 0.0
+	maxZoom := 23.0
+	zoom, _, err := query.Float64(uv, "zoom", config.zoom, &minZoom, &maxZoom)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("bad zoom value: %s", err), 400)
 		return
@@ -160,10 +310,78 @@ func static(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// scale
+	minScale := 1
+	maxScale := 2
+	scale, _, err := query.Int(uv, "scale", 1, &minScale, &maxScale)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("bad scale value: %s", err), 400)
+		return
+	}
+
+	// layers, either as a spec or a named style
+	spec := uv.Get("layers")
+	if style := uv.Get("style"); style != "" {
+		var ok bool
+		spec, ok = styles[style]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown style '%s'", style), 400)
+			return
+		}
+	}
+
+	var layers []tile.Layer
+	if spec != "" {
+		layers, err = tile.ParseLayers(spec, sources)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("bad layers value: %s", err), 400)
+			return
+		}
+	}
+
+	markers, err := stitch.ParseMarkers(uv["markers"], icons)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("bad markers value: %s", err), 400)
+		return
+	}
+
+	paths, err := stitch.ParsePaths(uv["path"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("bad path value: %s", err), 400)
+		return
+	}
+
+	polygons, err := stitch.ParsePolygons(uv["polygon"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("bad polygon value: %s", err), 400)
+		return
+	}
+
+	circles, err := stitch.ParseCircles(uv["circle"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("bad circle value: %s", err), 400)
+		return
+	}
+
 	pronto := query.Bool(uv, "pronto") && config.pronto
 
+	r := stitch.Request{
+		Width:    width,
+		Height:   height,
+		Zoom:     zoom,
+		Lat:      lat,
+		Long:     long,
+		Label:    label(layers),
+		Scale:    scale,
+		Layers:   layers,
+		Markers:  markers,
+		Paths:    paths,
+		Polygons: polygons,
+		Circles:  circles,
+	}
+
 	if req.Method == http.MethodPost {
-		err := s.Queue(width, height, zoom, lat, long, config.label)
+		err := s.Queue(r)
 		if err != nil {
 			http.Error(w, "could not queue", 500)
 			return
@@ -175,15 +393,22 @@ func static(w http.ResponseWriter, req *http.Request) {
 	}
 
 	var path string
+	var degraded bool
 	if pronto {
-		path, err = s.StaticImage(width, height, zoom, lat, long, config.label)
+		// the image is given up if the client goes away
+		path, degraded, err = s.StaticImage(req.Context(), r)
+		if errors.Is(err, context.DeadlineExceeded) {
+			log.Println(err)
+			http.Error(w, "timed out making static image", http.StatusGatewayTimeout)
+			return
+		}
 		if err != nil {
 			log.Println(err)
 			http.Error(w, "could not get static image", 500)
 			return
 		}
 	} else {
-		path = s.Stitch(width, height, zoom, lat, long, config.label)
+		path, degraded = s.Stitch(r)
 	}
 
 	if path == "" {
@@ -198,6 +423,14 @@ func static(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// degraded images have placeholders for tiles that failed, and are cached for a shorter time, as they are made again later
+	maxAge := config.maxage
+	if degraded {
+		w.Header().Set("X-Slipee-Degraded", "true")
+		maxAge = config.degradedmaxage
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(maxAge.Seconds())))
+
 	_, err = w.Write(b)
 
 	if err != nil {
@@ -208,6 +441,40 @@ func static(w http.ResponseWriter, req *http.Request) {
 	return
 }
 
+// userAgent returns the User-Agent for tile server requests, with the version of slipee.
+// Binaries not built by goreleaser use the module version, if they are installed with go get.
+func userAgent() string {
+	v := version
+	if info, ok := debug.ReadBuildInfo(); ok && v == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		v = strings.TrimPrefix(info.Main.Version, "v")
+	}
+
+	return fmt.Sprintf("Slipee/%s (+https://github.com/krilor/slipee)", v)
+}
+
+// redactUserinfo returns rawurl with any user name and password replaced, for logging.
+// Urls that can not be parsed are replaced as a whole, as there is no telling what is in them.
+func redactUserinfo(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "redacted"
+	}
+	if u.User != nil {
+		u.User = url.User("redacted")
+	}
+	return u.String()
+}
+
+// redactHeaders returns the names of headers with their values replaced, for logging, in the format of the headers flag
+func redactHeaders(headers map[string]string) string {
+	var names []string
+	for name := range headers {
+		names = append(names, name+": redacted")
+	}
+	sort.Strings(names)
+	return strings.Join(names, "|")
+}
+
 // Usage prints the cli usage
 func usage() {
 	flag.Usage()
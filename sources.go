@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/krilor/slipee/internal/tile"
+	"github.com/pkg/errors"
+)
+
+// defaultSource is the name of the source given by -tileserver
+const defaultSource = "default"
+
+// sourcesFile is the format of the file given by -sources
+//
+//	{
+//	  "sources": {
+//	    "satellite": {"url": "https://example.com/sat/{z}/{x}/{y}.jpg"},
+//	    "roads": {"url": "https://example.com/roads/{z}/{x}/{y}.png", "tilesize": 512}
+//	  },
+//	  "styles": {
+//	    "hybrid": "satellite,roads:0.8:multiply"
+//	  }
+//	}
+type sourcesFile struct {
+	Sources map[string]tile.Config `json:"sources"`
+	Styles  map[string]string      `json:"styles"`
+}
+
+// loadSources opens the named tile sources and reads the named styles in the sources file at path.
+// The default source is added as "default". Sources are opened with opts, overridden by their config.
+func loadSources(path string, def tile.TileSource, opts []tile.Option) (map[string]tile.TileSource, map[string]string, error) {
+	sources := map[string]tile.TileSource{defaultSource: def}
+	styles := map[string]string{}
+
+	if path == "" {
+		return sources, styles, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not read sources file")
+	}
+
+	f := sourcesFile{}
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "could not parse sources file %s", path)
+	}
+
+	for name, c := range f.Sources {
+		if name == defaultSource {
+			return nil, nil, fmt.Errorf("source name '%s' is reserved for -tileserver", defaultSource)
+		}
+
+		sources[name], err = c.Open(opts...)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "could not open source %s", name)
+		}
+	}
+
+	for name, spec := range f.Styles {
+		_, err = tile.ParseLayers(spec, sources)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid style %s", name)
+		}
+		styles[name] = spec
+	}
+
+	return sources, styles, nil
+}